@@ -0,0 +1,287 @@
+package container_monitor
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	CGROUP_V1          = 1                    // Legacy cgroup hierarchy.
+	CGROUP_V2          = 2                    // Unified cgroup hierarchy.
+	CGROUP_ROOT        = "/sys/fs/cgroup"     // Cgroup file system mount point.
+	CGROUP_SELF_FILE   = "/proc/self/cgroup"  // Cgroup membership of the current process.
+	CGROUP_V2_CONTROLS = "cgroup.controllers" // File present only on the unified hierarchy.
+)
+
+const MOUNTINFO_FILE = "/proc/self/mountinfo" // Mounts of the current process.
+
+// Container ID in cgroup paths and mounts of docker and other runtimes.
+var CONTAINER_ID_PATTERN = regexp.MustCompile("[0-9a-f]{64}")
+
+// Returns ID of the container of the current process, empty if the process
+// does not run in a container. The ID is taken from the cgroup path, or from
+// the mounts of the container files if the cgroup namespace hides the path.
+func ContainerID() string {
+	paths, err := readSelfCgroup(CGROUP_SELF_FILE)
+	if err == nil {
+		for _, path := range paths {
+			id := CONTAINER_ID_PATTERN.FindString(path)
+			if id != "" {
+				return id
+			}
+		}
+	}
+	data, err := ioutil.ReadFile(MOUNTINFO_FILE)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.Contains(line, "/containers/") {
+			id := CONTAINER_ID_PATTERN.FindString(line)
+			if id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// Reads CPU and memory usage of the container from its own cgroup instead of
+// the host-wide figures.
+type CgroupReader struct {
+	Version     int    // Cgroup hierarchy version: CGROUP_V1 or CGROUP_V2.
+	cpu_path    string // Directory with the CPU accounting files.
+	quota_path  string // Directory with the CPU quota files.
+	memory_path string // Directory with the memory accounting files.
+}
+
+// Returns new cgroup reader for the cgroup of the current process.
+// Detects cgroup v1 or v2 automatically.
+//
+// return: Instance of *CgroupReader or error if cgroups are not available.
+func NewCgroupReader() (*CgroupReader, error) {
+	paths, err := readSelfCgroup(CGROUP_SELF_FILE)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(CGROUP_ROOT, CGROUP_V2_CONTROLS))
+	if err == nil {
+		path := cgroupDir(CGROUP_ROOT, paths[""], "cpu.stat")
+		return &CgroupReader{
+			Version:     CGROUP_V2,
+			cpu_path:    path,
+			quota_path:  path,
+			memory_path: path,
+		}, nil
+	}
+
+	cpu_path := ""
+	for _, mount := range []string{"cpuacct", "cpu,cpuacct", "cpuacct,cpu"} {
+		root := filepath.Join(CGROUP_ROOT, mount)
+		if _, err := os.Stat(root); err == nil {
+			cpu_path = cgroupDir(root, paths["cpuacct"], "cpuacct.usage")
+			break
+		}
+	}
+	quota_path := ""
+	for _, mount := range []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"} {
+		root := filepath.Join(CGROUP_ROOT, mount)
+		if _, err := os.Stat(root); err == nil {
+			quota_path = cgroupDir(root, paths["cpu"], "cpu.cfs_quota_us")
+			break
+		}
+	}
+	memory_path := cgroupDir(
+		filepath.Join(CGROUP_ROOT, "memory"), paths["memory"],
+		"memory.usage_in_bytes")
+	if cpu_path == "" {
+		return nil, errors.New("can not find cpuacct cgroup")
+	}
+	return &CgroupReader{
+		Version:     CGROUP_V1,
+		cpu_path:    cpu_path,
+		quota_path:  quota_path,
+		memory_path: memory_path,
+	}, nil
+}
+
+// Returns total CPU time consumed by the cgroup in nanoseconds.
+func (r *CgroupReader) CPUUsage() (uint64, error) {
+	if r.Version == CGROUP_V2 {
+		stat, err := readCgroupStat(filepath.Join(r.cpu_path, "cpu.stat"))
+		if err != nil {
+			return 0, err
+		}
+		usage, ok := stat["usage_usec"]
+		if !ok {
+			return 0, errors.New("can not find usage_usec in cpu.stat")
+		}
+		return usage * 1000, nil
+	}
+	return readCgroupUint(filepath.Join(r.cpu_path, "cpuacct.usage"))
+}
+
+// Returns memory used by the cgroup in bytes. Inactive page cache is not
+// counted, the same way as docker stats does.
+func (r *CgroupReader) MemoryUsage() (uint64, error) {
+	usage_file, inactive_field := "memory.current", "inactive_file"
+	if r.Version == CGROUP_V1 {
+		usage_file, inactive_field = "memory.usage_in_bytes", "total_inactive_file"
+	}
+	usage, err := readCgroupUint(filepath.Join(r.memory_path, usage_file))
+	if err != nil {
+		return 0, err
+	}
+	stat, err := readCgroupStat(filepath.Join(r.memory_path, "memory.stat"))
+	if err != nil {
+		return usage, nil
+	}
+	if inactive, ok := stat[inactive_field]; ok && inactive < usage {
+		usage -= inactive
+	}
+	return usage, nil
+}
+
+// Returns CPU cores allowed by the cgroup quota, for example 1.5 for a
+// container started with --cpus=1.5. Returns 0 if the quota is not set.
+func (r *CgroupReader) CPULimit() (float64, error) {
+	if r.Version == CGROUP_V2 {
+		data, err := ioutil.ReadFile(filepath.Join(r.quota_path, "cpu.max"))
+		if err != nil {
+			return 0.0, err
+		}
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0.0, errors.New("can not parse cpu.max")
+		}
+		if fields[0] == "max" {
+			return 0.0, nil
+		}
+		quota, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0.0, err
+		}
+		period, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || period <= 0 {
+			return 0.0, errors.New("can not parse cpu.max period")
+		}
+		return quota / period, nil
+	}
+	if r.quota_path == "" {
+		return 0.0, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(r.quota_path, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0.0, err
+	}
+	quota, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+	if err != nil {
+		return 0.0, err
+	}
+	if quota <= 0 {
+		return 0.0, nil
+	}
+	period, err := readCgroupUint(filepath.Join(r.quota_path, "cpu.cfs_period_us"))
+	if err != nil || period == 0 {
+		return 0.0, errors.New("can not read cpu.cfs_period_us")
+	}
+	return quota / float64(period), nil
+}
+
+// Returns memory limit of the cgroup in bytes. Returns 0 if the limit is not
+// set. Cgroup v1 reports a huge number instead of "max", so the caller should
+// compare the limit with the host memory.
+func (r *CgroupReader) MemoryLimit() (uint64, error) {
+	if r.Version == CGROUP_V2 {
+		data, err := ioutil.ReadFile(filepath.Join(r.memory_path, "memory.max"))
+		if err != nil {
+			return 0, err
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "max" {
+			return 0, nil
+		}
+		return strconv.ParseUint(value, 10, 64)
+	}
+	return readCgroupUint(filepath.Join(r.memory_path, "memory.limit_in_bytes"))
+}
+
+// Parses /proc/self/cgroup file.
+//
+// param: file_name string   Path to the cgroup membership file.
+// return: Map of controller name to cgroup path. The unified hierarchy path
+//         is stored with the empty key.
+func readSelfCgroup(file_name string) (map[string]string, error) {
+	file, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+	return paths, scanner.Err()
+}
+
+// Returns cgroup directory for the given hierarchy root. Inside a container
+// the own cgroup is usually mounted as the root, so the root is used when the
+// full path does not contain the accounting file.
+//
+// params: root      string   Hierarchy mount point.
+//         path      string   Cgroup path from /proc/self/cgroup.
+//         test_file string   Accounting file expected in the directory.
+func cgroupDir(root string, path string, test_file string) string {
+	dir := filepath.Join(root, path)
+	if _, err := os.Stat(filepath.Join(dir, test_file)); err == nil {
+		return dir
+	}
+	return root
+}
+
+// Reads single unsigned integer value from cgroup file.
+func readCgroupUint(file_name string) (uint64, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Reads "key value" lines from cgroup stat file.
+func readCgroupStat(file_name string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, err
+	}
+	stat := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		stat[fields[0]] = value
+	}
+	return stat, nil
+}
@@ -0,0 +1,249 @@
+package container_monitor
+
+import (
+	"sort"
+	"sync"
+)
+
+// Handles control commands of stress tests. Keeps the registry of running
+// monitors, the sampler shared by them and the sinks of their samples.
+// Samples which can not be written to Redis are queued in memory or in spool
+// files up to their limits, other sinks can be added.
+type Controller struct {
+	info_factory *SystemInfoFactory           // System info factory.
+	sampler      *Sampler                     // Sampler shared by all tests.
+	monitors     map[string]*ContainerMonitor // Running monitors by test ID.
+	redis        *RedisSink                   // Redis store of samples.
+	sinks        *FanoutSink                  // All sinks of samples.
+	lock         sync.Mutex                   // Monitors lock.
+}
+
+// Returns new controller instance.
+//
+// param: factory *SystemInfoFactory   System info factory.
+func NewController(factory *SystemInfoFactory) *Controller {
+	redis := NewRedisSink(factory)
+	sinks := NewFanoutSink()
+	sinks.AddLossless(redis)
+	return &Controller{
+		info_factory: factory,
+		sampler:      NewSampler(factory),
+		monitors:     make(map[string]*ContainerMonitor),
+		redis:        redis,
+		sinks:        sinks,
+	}
+}
+
+// Adds the sink of samples of all tests, in addition to Redis. Samples are
+// dropped for the sink if it falls behind.
+//
+// param: sink Sink   Sink of samples.
+func (c *Controller) AddSink(sink Sink) {
+	c.sinks.Add(sink)
+}
+
+// Stops all running tests and closes the sinks.
+func (c *Controller) Close() error {
+	c.lock.Lock()
+	test_ids := make([]string, 0, len(c.monitors))
+	for test_id := range c.monitors {
+		test_ids = append(test_ids, test_id)
+	}
+	c.lock.Unlock()
+	for _, test_id := range test_ids {
+		c.stopTest(test_id)
+	}
+	return c.sinks.Close()
+}
+
+// Executes the command of the message.
+//
+// param: message *redisMessage   Control message.
+// return: Reply with the outcome of the command.
+func (c *Controller) Handle(message *redisMessage) *RedisReply {
+	err := validateRedisMessage(message)
+	if err != nil {
+		return newRedisReply(message, STATUS_ERROR, err)
+	}
+	switch message.Command {
+	case START_COMMAND:
+		return newRedisReply(message, STATUS_STARTED,
+			c.startTest(message.TestID, message.Options))
+	case STOP_COMMAND:
+		return newRedisReply(
+			message, STATUS_STOPPED, c.stopTest(message.TestID))
+	case PAUSE_COMMAND:
+		return newRedisReply(
+			message, STATUS_PAUSED, c.pauseTest(message.TestID, true))
+	case RESUME_COMMAND:
+		return newRedisReply(
+			message, STATUS_RESUMED, c.pauseTest(message.TestID, false))
+	case ABORT_COMMAND:
+		return newRedisReply(
+			message, STATUS_ABORTED, c.abortTest(message.TestID))
+	case KEEPALIVE_COMMAND:
+		return newRedisReply(
+			message, STATUS_OK, c.keepalive(message.TestID))
+	case STATUS_COMMAND:
+		reply := newRedisReply(message, STATUS_OK, nil)
+		reply.Tests, err = c.status(message.TestID)
+		if err != nil {
+			return newRedisReply(message, STATUS_ERROR, err)
+		}
+		return reply
+	case SNAPSHOT_COMMAND:
+		reply := newRedisReply(message, STATUS_OK, nil)
+		reply.Snapshot = c.info_factory.CollectSample()
+		return reply
+	case REPORT_COMMAND:
+		reply := newRedisReply(message, STATUS_OK, nil)
+		reply.Report, err = c.info_factory.ReadSystemInfo(message.TestID)
+		if err != nil {
+			return newRedisReply(message, STATUS_ERROR, err)
+		}
+		return reply
+	default:
+		// LIST_COMMAND, other commands are rejected by validation.
+		reply := newRedisReply(message, STATUS_OK, nil)
+		reply.TestIDs, err = c.info_factory.ListTests()
+		if err != nil {
+			return newRedisReply(message, STATUS_ERROR, err)
+		}
+		return reply
+	}
+}
+
+// Starts gathering information about the container system for the test.
+// Several tests can run at the same time.
+//
+// params: test_id string         Test ID.
+//         options *TestOptions   Test options, nil for defaults.
+func (c *Controller) startTest(test_id string, options *TestOptions) error {
+	filter, err := newSampleFilter(options)
+	if err != nil {
+		return &InvalidRequestError{Err: err}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.monitors[test_id]; ok {
+		return ErrTestRunning
+	}
+	monitor := newContainerMonitor(c.sinks, c.sampler, test_id, filter)
+	c.monitors[test_id] = monitor
+	go func() {
+		monitor.Run()
+		c.removeMonitor(test_id, monitor)
+	}()
+	return nil
+}
+
+// Spools samples which can not be written to Redis to files in the
+// directory. Samples are kept in memory if the directory is not set.
+// Samples left in the directory by the previous run are written in
+// background.
+//
+// param: dir string   Spool directory.
+func (c *Controller) SetSpoolDir(dir string) error {
+	err := c.redis.SetSpoolDir(dir)
+	if err != nil {
+		return err
+	}
+	c.Flush()
+	return nil
+}
+
+// Removes the monitor from the registry after it has finished. The monitor
+// finishes by itself when the maximal duration of the test has passed or the
+// stress client is lost.
+//
+// params: test_id string              Test ID.
+//         monitor *ContainerMonitor   Finished monitor.
+func (c *Controller) removeMonitor(
+	test_id string, monitor *ContainerMonitor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.monitors[test_id] == monitor {
+		delete(c.monitors, test_id)
+	}
+}
+
+// Stops gathering information about the container system for the test.
+// Only the test with the given ID is stopped.
+func (c *Controller) stopTest(test_id string) error {
+	c.lock.Lock()
+	monitor, ok := c.monitors[test_id]
+	delete(c.monitors, test_id)
+	c.lock.Unlock()
+	if !ok {
+		return ErrTestNotRunning
+	}
+	monitor.Stop()
+	return nil
+}
+
+// Pauses or resumes writing of samples of the test.
+//
+// params: test_id string   Test ID.
+//         paused  bool     True to pause, false to resume.
+func (c *Controller) pauseTest(test_id string, paused bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	monitor, ok := c.monitors[test_id]
+	if !ok {
+		return ErrTestNotRunning
+	}
+	monitor.SetPaused(paused)
+	return nil
+}
+
+// Resets the inactivity watchdog of the test.
+func (c *Controller) keepalive(test_id string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	monitor, ok := c.monitors[test_id]
+	if !ok {
+		return ErrTestNotRunning
+	}
+	monitor.Keepalive()
+	return nil
+}
+
+// Writes samples kept while Redis was unreachable, of the running tests and
+// of the spool directory. The samples are written in background, the call
+// does not block.
+func (c *Controller) Flush() {
+	go c.redis.Flush()
+}
+
+// Stops the test if it is running and deletes its data.
+func (c *Controller) abortTest(test_id string) error {
+	err := c.stopTest(test_id)
+	if err != nil && err != ErrTestNotRunning {
+		return err
+	}
+	err = c.redis.RemoveSpool(test_id)
+	if err != nil {
+		return err
+	}
+	return c.info_factory.DeleteTest(test_id)
+}
+
+// Returns status of the running test, or of all running tests if the test
+// ID is empty.
+func (c *Controller) status(test_id string) ([]*TestStatus, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if test_id != "" {
+		monitor, ok := c.monitors[test_id]
+		if !ok {
+			return nil, ErrTestNotRunning
+		}
+		return []*TestStatus{monitor.Status()}, nil
+	}
+	statuses := make([]*TestStatus, 0, len(c.monitors))
+	for _, monitor := range c.monitors {
+		statuses = append(statuses, monitor.Status())
+	}
+	sort.Sort(ByTestID(statuses))
+	return statuses, nil
+}
@@ -6,8 +6,10 @@ import (
 	"github.com/sevlyar/go-daemon"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Application for collect information about media server system.
@@ -66,18 +68,121 @@ func main() {
 	log.Println("- - - - - - - - - - - - - - -")
 	log.Println("system monitor daemon started")
 	redis_url := ""
+	spool_dir := ""
+	stream_consumer := ""
+	socket_path := ""
+	socket_mode := ""
+	http_addr := ""
+	metrics_interval := ""
+	otlp_endpoint := ""
+	line_targets := map[string]string{}
 	for _, e := range os.Environ() {
-		pair := strings.Split(e, "=")
-		if pair[0] == "REDIS_URL" {
+		pair := strings.SplitN(e, "=", 2)
+		switch pair[0] {
+		case "REDIS_URL":
 			redis_url = pair[1]
-			break
+		case "SPOOL_DIR":
+			spool_dir = pair[1]
+		case "STREAM_CONSUMER":
+			stream_consumer = pair[1]
+		case "SOCKET_PATH":
+			socket_path = pair[1]
+		case "SOCKET_MODE":
+			socket_mode = pair[1]
+		case "HTTP_ADDR":
+			http_addr = pair[1]
+		case "METRICS_INTERVAL":
+			metrics_interval = pair[1]
+		case "OTLP_ENDPOINT":
+			otlp_endpoint = pair[1]
+		case "INFLUX_TARGET":
+			line_targets[container_monitor.FORMAT_INFLUX] = pair[1]
+		case "GRAPHITE_TARGET":
+			line_targets[container_monitor.FORMAT_GRAPHITE] = pair[1]
+		case "STATSD_TARGET":
+			line_targets[container_monitor.FORMAT_STATSD] = pair[1]
 		}
 	}
 
 	listener = container_monitor.NewRedisListener(redis_url, "", 0)
+	if spool_dir != "" {
+		err = listener.SetSpoolDir(spool_dir)
+		if err != nil {
+			log.Println("Unable to use spool directory:", err)
+		}
+	}
+	if stream_consumer != "" {
+		listener.UseStream(stream_consumer)
+	}
 	go listener.Listen()
 	defer listener.Close()
 
+	if socket_path != "" {
+		socket := container_monitor.NewSocketListener(
+			socket_path, listener.Controller())
+		if socket_mode != "" {
+			mode, err := strconv.ParseUint(socket_mode, 8, 32)
+			if err != nil {
+				log.Println("Invalid socket mode:", err)
+			} else {
+				socket.SetMode(os.FileMode(mode))
+			}
+		}
+		go func() {
+			err := socket.Listen()
+			if err != nil {
+				log.Println("Unable to listen socket:", err)
+			}
+		}()
+		defer socket.Close()
+	}
+
+	if http_addr != "" {
+		server := container_monitor.NewHTTPServer(
+			http_addr, listener.Controller())
+		exporter := container_monitor.NewPrometheusExporter(
+			listener.Controller())
+		if metrics_interval != "" {
+			interval, err := time.ParseDuration(metrics_interval)
+			if err != nil {
+				log.Println("Invalid metrics interval:", err)
+			} else {
+				go func() {
+					err := exporter.Run(&container_monitor.TestOptions{
+						Interval: container_monitor.Duration(interval),
+					})
+					if err != nil {
+						log.Println("Unable to sample live metrics:", err)
+					}
+				}()
+			}
+		}
+		defer exporter.Stop()
+		listener.Controller().AddSink(exporter)
+		server.Handle("/metrics", exporter)
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil {
+				log.Println("Unable to serve HTTP:", err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	if otlp_endpoint != "" {
+		listener.Controller().AddSink(
+			container_monitor.NewOTLPExporter(otlp_endpoint))
+	}
+
+	for format, target := range line_targets {
+		exporter, err := container_monitor.NewLineExporter(format, target)
+		if err != nil {
+			log.Println("Unable to export samples:", err)
+			continue
+		}
+		listener.Controller().AddSink(exporter)
+	}
+
 	err = daemon.ServeSignals()
 	if err != nil {
 		log.Println("Error:", err)
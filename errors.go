@@ -0,0 +1,51 @@
+package container_monitor
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrTestNotFound   = errors.New("test not found")       // No data of the test.
+	ErrNoSamples      = errors.New("test has no samples")  // Test started but not sampled.
+	ErrTestRunning    = errors.New("test already running") // Test started twice.
+	ErrTestNotRunning = errors.New("test not running")     // Stop of unknown test.
+	ErrUnknownCommand = errors.New("unknown command")      // Unsupported command.
+	ErrEmptyTestID    = errors.New("empty test ID")        // Message without test ID.
+	ErrNoMonitor      = errors.New("no monitor listening") // Command not delivered.
+	ErrReplyTimeout   = errors.New("reply timeout")        // Monitor did not reply in time.
+	ErrSpoolFull      = errors.New("spool is full")        // Sample not spooled.
+	ErrNotSocket      = errors.New("file is not a socket") // Socket path is taken.
+	ErrSinkTimeout    = errors.New("sink timeout")         // Test event not handled in time.
+)
+
+// Error of an invalid command or invalid test options.
+type InvalidRequestError struct {
+	Err error // Validation error.
+}
+
+// Returns error message.
+func (e *InvalidRequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Returns the validation error.
+func (e *InvalidRequestError) Unwrap() error {
+	return e.Err
+}
+
+// Error of stored test data which can not be decoded.
+type CorruptDataError struct {
+	Key   string // Redis key.
+	Field string // Hash field, empty for plain keys.
+	Err   error  // Decoding error.
+}
+
+// Returns error message.
+func (e *CorruptDataError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("corrupt data in %s: %s", e.Key, e.Err.Error())
+	}
+	return fmt.Sprintf(
+		"corrupt data in %s field %s: %s", e.Key, e.Field, e.Err.Error())
+}
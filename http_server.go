@@ -0,0 +1,251 @@
+package container_monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const MAX_HTTP_BODY = 1 << 20 // Maximal size of request body.
+
+// Reply of health endpoint.
+type healthReply struct {
+	Status string // STATUS_OK or STATUS_ERROR.
+	Error  string // Redis error if the monitor is not healthy.
+	Tests  int    // Count of running tests.
+}
+
+// Embedded HTTP server for controlling tests and fetching reports.
+//
+// Endpoints:
+//   POST /tests/{id}/start    Starts the test, body is optional TestOptions.
+//   POST /tests/{id}/stop     Stops the test.
+//   GET  /tests               Running tests and tests with stored data.
+//   GET  /tests/{id}/report   SystemInfo of the test, ?top=N limits the top.
+//   GET  /health              Redis availability and count of running tests.
+type HTTPServer struct {
+	addr       string         // Listen address.
+	controller *Controller    // Control commands handler.
+	listener   net.Listener   // HTTP listener.
+	mux        *http.ServeMux // Request router.
+	lock       sync.Mutex     // Listener lock.
+}
+
+// Returns new instance of HTTP server.
+//
+// params: addr       string        Listen address, for example ":8080".
+//         controller *Controller   Control commands handler.
+func NewHTTPServer(addr string, controller *Controller) *HTTPServer {
+	s := &HTTPServer{
+		addr:       addr,
+		controller: controller,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/tests", s.handleTests)
+	s.mux.HandleFunc("/tests/", s.handleTest)
+	s.mux.HandleFunc("/health", s.handleHealth)
+	return s
+}
+
+// Adds handler of the endpoint, for example "/metrics".
+// Must be called before ListenAndServe.
+//
+// params: pattern string         Endpoint path.
+//         handler http.Handler   Endpoint handler.
+func (s *HTTPServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Serves HTTP requests until the server is closed.
+func (s *HTTPServer) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.listener = listener
+	s.lock.Unlock()
+	err = http.Serve(listener, s.mux)
+	if s.closed() {
+		return nil
+	}
+	return err
+}
+
+// Closes the server.
+func (s *HTTPServer) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+		s.listener = nil
+	}
+}
+
+// Returns true if the server is closed.
+func (s *HTTPServer) closed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.listener == nil
+}
+
+// Handles GET /tests.
+func (s *HTTPServer) handleTests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	reply := s.controller.Handle(newRedisMessage(LIST_COMMAND, ""))
+	if reply.Status == STATUS_OK {
+		status := s.controller.Handle(newRedisMessage(STATUS_COMMAND, ""))
+		reply.Tests = status.Tests
+	}
+	writeHTTPReply(w, reply)
+}
+
+// Handles POST /tests/{id}/start, POST /tests/{id}/stop and
+// GET /tests/{id}/report.
+func (s *HTTPServer) handleTest(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tests/"), "/")
+	separator := strings.LastIndex(path, "/")
+	if separator <= 0 {
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	test_id, action := path[:separator], path[separator+1:]
+	switch action {
+	case "start":
+		if r.Method != http.MethodPost {
+			break
+		}
+		message := newRedisMessage(START_COMMAND, test_id)
+		body, err := ioutil.ReadAll(
+			http.MaxBytesReader(w, r.Body, MAX_HTTP_BODY))
+		if err != nil {
+			writeHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			message.Options = &TestOptions{}
+			err = json.Unmarshal(body, message.Options)
+			if err != nil {
+				writeHTTPError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		writeHTTPReply(w, s.controller.Handle(message))
+		return
+	case "stop":
+		if r.Method != http.MethodPost {
+			break
+		}
+		writeHTTPReply(w, s.controller.Handle(
+			newRedisMessage(STOP_COMMAND, test_id)))
+		return
+	case "report":
+		if r.Method != http.MethodGet {
+			break
+		}
+		s.writeReport(w, r, test_id)
+		return
+	default:
+		writeHTTPError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// Writes SystemInfo of the test.
+//
+// params: w       http.ResponseWriter   Response writer.
+//         r       *http.Request         Request with optional top parameter.
+//         test_id string                Test ID.
+func (s *HTTPServer) writeReport(
+	w http.ResponseWriter, r *http.Request, test_id string) {
+	top := 0
+	if value := r.URL.Query().Get("top"); value != "" {
+		var err error
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 0 {
+			writeHTTPError(w, http.StatusBadRequest, "invalid top")
+			return
+		}
+	}
+	system_info, err := s.controller.info_factory.ReadTopSystemInfo(
+		test_id, top)
+	switch err {
+	case nil:
+		writeHTTPJSON(w, http.StatusOK, system_info)
+	case ErrTestNotFound, ErrNoSamples:
+		writeHTTPError(w, http.StatusNotFound, err.Error())
+	default:
+		writeHTTPError(w, httpErrorCode(err), err.Error())
+	}
+}
+
+// Handles GET /health. Replies 503 if Redis is unreachable.
+func (s *HTTPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	statuses, _ := s.controller.status("")
+	reply := &healthReply{Status: STATUS_OK, Tests: len(statuses)}
+	err := s.controller.info_factory.Ping()
+	if err != nil {
+		reply.Status = STATUS_ERROR
+		reply.Error = err.Error()
+		writeHTTPJSON(w, http.StatusServiceUnavailable, reply)
+		return
+	}
+	writeHTTPJSON(w, http.StatusOK, reply)
+}
+
+// Writes command reply with HTTP status of the reply status.
+func writeHTTPReply(w http.ResponseWriter, reply *RedisReply) {
+	code := http.StatusOK
+	switch reply.Status {
+	case STATUS_ALREADY_RUNNING:
+		code = http.StatusConflict
+	case STATUS_NOT_FOUND:
+		code = http.StatusNotFound
+	case STATUS_ERROR:
+		code = httpErrorCode(reply.err)
+	}
+	writeHTTPJSON(w, code, reply)
+}
+
+// Returns HTTP status of the command error: 400 for invalid commands and
+// options, 503 if Redis is unreachable and 500 for other failures.
+func httpErrorCode(err error) int {
+	switch err.(type) {
+	case *InvalidRequestError:
+		return http.StatusBadRequest
+	case net.Error:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// Writes error reply.
+func writeHTTPError(w http.ResponseWriter, code int, message string) {
+	writeHTTPJSON(w, code, &RedisReply{Status: STATUS_ERROR, Error: message})
+}
+
+// Writes value as JSON response.
+//
+// params: w     http.ResponseWriter   Response writer.
+//         code  int                   HTTP status code.
+//         value interface{}           Response value.
+func writeHTTPJSON(w http.ResponseWriter, code int, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("can not marshal http response: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
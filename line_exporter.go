@@ -0,0 +1,334 @@
+package container_monitor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FORMAT_INFLUX   = "influx"   // InfluxDB line protocol.
+	FORMAT_GRAPHITE = "graphite" // Graphite plaintext protocol with tags.
+	FORMAT_STATSD   = "statsd"   // StatsD gauges with DogStatsD tags.
+
+	LINE_MEASUREMENT    = "container_monitor" // Measurement and metric prefix.
+	LINE_TIMEOUT        = time.Second * 5     // Network write timeout.
+	MAX_DATAGRAM_LENGTH = 1400                // Maximal UDP datagram payload.
+)
+
+// Transports supported by every format.
+var LINE_TRANSPORTS = map[string][]string{
+	FORMAT_INFLUX:   {"file", "http", "https", "udp"},
+	FORMAT_GRAPHITE: {"tcp", "udp"},
+	FORMAT_STATSD:   {"udp"},
+}
+
+// Value of one metric of the sample.
+type metricPoint struct {
+	Name  string   // Metric name.
+	Tags  []string // Tag names and values.
+	Value float64  // Metric value.
+}
+
+// Sink which writes samples as text lines of InfluxDB, Graphite or StatsD
+// protocol. Every line is tagged with the host name and the test ID.
+// TCP and UDP targets are written over one connection which is dialed again
+// after a failed write.
+//
+// Targets:
+//   influx     file:///path/to/file, http://host:8086/write?db=name,
+//              udp://host:8089
+//   graphite   tcp://host:2003, udp://host:2003
+//   statsd     udp://host:8125
+type LineExporter struct {
+	format    string     // Line format.
+	target    *url.URL   // Target URL.
+	host_name string     // Host tag.
+	conn      net.Conn   // TCP or UDP connection, nil until the first write.
+	lock      sync.Mutex // Connection lock.
+}
+
+// Returns new instance of line exporter.
+//
+// params: format string   FORMAT_INFLUX, FORMAT_GRAPHITE or FORMAT_STATSD.
+//         target string   Target URL.
+func NewLineExporter(format string, target string) (*LineExporter, error) {
+	transports, ok := LINE_TRANSPORTS[format]
+	if !ok {
+		return nil, errors.New("unknown line format " + format)
+	}
+	target_url, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	supported := false
+	for _, transport := range transports {
+		supported = supported || transport == target_url.Scheme
+	}
+	if !supported {
+		return nil, fmt.Errorf("%s can not be written to %s",
+			format, target_url.Scheme)
+	}
+	host_name, err := os.Hostname()
+	if err != nil {
+		log.Printf("can not get hostname: %s", err.Error())
+	}
+	return &LineExporter{
+		format:    format,
+		target:    target_url,
+		host_name: host_name,
+	}, nil
+}
+
+// Writes the sample to the target.
+//
+// param: sample *Sample   Collected sample.
+func (e *LineExporter) Write(sample *Sample) error {
+	tags := []string{"host", e.host_name, "test_id", sample.TestID}
+	lines := []string{}
+	for _, point := range metricPoints(sample) {
+		lines = append(lines, e.formatLine(point, tags, sample.Time))
+	}
+	switch e.target.Scheme {
+	case "file":
+		return writeLinesToFile(e.target.Path, lines)
+	case "http", "https":
+		return postLines(e.target.String(), lines)
+	case "udp":
+		return e.send(splitDatagrams(lines, MAX_DATAGRAM_LENGTH))
+	}
+	return e.send([]string{strings.Join(lines, "\n") + "\n"})
+}
+
+// Closes the connection of the exporter.
+func (e *LineExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.conn == nil {
+		return nil
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// Writes the payloads over the connection. If the write fails, the
+// connection is dialed again and the payloads are written once more.
+//
+// param: payloads []string   TCP stream chunks or UDP datagrams.
+func (e *LineExporter) send(payloads []string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	err := e.write(payloads)
+	if err == nil {
+		return nil
+	}
+	log.Printf("can not write lines to %s, reconnecting: %s",
+		e.target.Host, err.Error())
+	return e.write(payloads)
+}
+
+// Writes the payloads over the connection, dials it if it is not open.
+// The connection is closed if the write fails.
+//
+// param: payloads []string   TCP stream chunks or UDP datagrams.
+func (e *LineExporter) write(payloads []string) error {
+	if e.conn == nil {
+		conn, err := net.DialTimeout(
+			e.target.Scheme, e.target.Host, LINE_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		e.conn = conn
+	}
+	e.conn.SetWriteDeadline(time.Now().Add(LINE_TIMEOUT))
+	for _, payload := range payloads {
+		_, err := e.conn.Write([]byte(payload))
+		if err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the point in the line format.
+//
+// params: point *metricPoint   Metric value.
+//         tags  []string       Common tag names and values.
+//         now   time.Time      Sample time.
+func (e *LineExporter) formatLine(
+	point *metricPoint, tags []string, now time.Time) string {
+	tags = append(append([]string{}, tags...), point.Tags...)
+	value := strconv.FormatFloat(point.Value, 'f', -1, 64)
+	switch e.format {
+	case FORMAT_INFLUX:
+		line := LINE_MEASUREMENT
+		for i := 0; i+1 < len(tags); i += 2 {
+			if tags[i+1] != "" {
+				line += "," + escapeInflux(tags[i]) + "=" +
+					escapeInflux(tags[i+1])
+			}
+		}
+		return fmt.Sprintf("%s %s=%s %d",
+			line, escapeInflux(point.Name), value, now.UnixNano())
+	case FORMAT_GRAPHITE:
+		line := LINE_MEASUREMENT + "." + point.Name
+		for i := 0; i+1 < len(tags); i += 2 {
+			if tags[i+1] != "" {
+				line += ";" + tags[i] + "=" + escapeGraphite(tags[i+1])
+			}
+		}
+		return fmt.Sprintf("%s %s %d", line, value, now.Unix())
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i+1] != "" {
+			pairs = append(pairs, tags[i]+":"+escapeStatsD(tags[i+1]))
+		}
+	}
+	return fmt.Sprintf("%s.%s:%s|g|#%s",
+		LINE_MEASUREMENT, point.Name, value, strings.Join(pairs, ","))
+}
+
+// Returns values of the sample.
+//
+// param: sample *Sample   Collected sample.
+func metricPoints(sample *Sample) []*metricPoint {
+	points := []*metricPoint{{Name: "cpu_limit", Value: sample.CPULimit}}
+	if sample.Has(COLLECTOR_CPU) {
+		points = append(points,
+			&metricPoint{Name: "cpu_usage", Value: sample.CPUusage})
+	}
+	if sample.Has(COLLECTOR_MEMORY) && sample.VirtualMemory != nil {
+		points = append(points,
+			&metricPoint{Name: "memory_total",
+				Value: float64(sample.VirtualMemory.Total)},
+			&metricPoint{Name: "memory_used",
+				Value: float64(sample.VirtualMemory.Used)},
+			&metricPoint{Name: "memory_percent",
+				Value: sample.VirtualMemory.UsedPercent})
+	}
+	if sample.Has(COLLECTOR_SWAP) && sample.SWAPmemory != nil {
+		points = append(points,
+			&metricPoint{Name: "swap_total",
+				Value: float64(sample.SWAPmemory.Total)},
+			&metricPoint{Name: "swap_used",
+				Value: float64(sample.SWAPmemory.Used)},
+			&metricPoint{Name: "swap_percent",
+				Value: sample.SWAPmemory.UsedPercent})
+	}
+	if !sample.Has(COLLECTOR_PROCESSES) {
+		return points
+	}
+	for _, process := range sample.Processes {
+		tags := []string{
+			"pid", strconv.Itoa(int(process.PID)), "name", process.Name}
+		points = append(points,
+			&metricPoint{Name: "process_cpu_percent", Tags: tags,
+				Value: process.CPUPercent},
+			&metricPoint{Name: "process_threads", Tags: tags,
+				Value: float64(process.NumThreads)})
+		if process.MemoryInfo != nil {
+			points = append(points, &metricPoint{Name: "process_rss",
+				Tags: tags, Value: float64(process.MemoryInfo.RSS)})
+		}
+	}
+	return points
+}
+
+// Appends lines to the file.
+//
+// params: path  string     File path.
+//         lines []string   Lines without line breaks.
+func writeLinesToFile(path string, lines []string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(strings.Join(lines, "\n") + "\n")
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Posts lines to HTTP endpoint.
+//
+// params: target string     Endpoint URL.
+//         lines  []string   Lines without line breaks.
+func postLines(target string, lines []string) error {
+	client := &http.Client{Timeout: LINE_TIMEOUT}
+	response, err := client.Post(target, "text/plain; charset=utf-8",
+		strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s replied %s", target, response.Status)
+	}
+	return nil
+}
+
+// Joins lines to UDP datagrams of max length at most. A line is never split
+// between datagrams, a longer line is sent in its own datagram.
+//
+// params: lines []string   Lines without line breaks.
+//         max   int        Maximal datagram length.
+func splitDatagrams(lines []string, max int) []string {
+	datagrams := []string{}
+	datagram := []string{}
+	length := 0
+	for _, line := range lines {
+		if length > 0 && length+len(line) > max {
+			datagrams = append(datagrams, strings.Join(datagram, "\n"))
+			datagram = datagram[:0]
+			length = 0
+		}
+		datagram = append(datagram, line)
+		length += len(line) + 1
+	}
+	if len(datagram) > 0 {
+		datagrams = append(datagrams, strings.Join(datagram, "\n"))
+	}
+	return datagrams
+}
+
+// Escapes tag key, tag value or field key of InfluxDB line protocol.
+func escapeInflux(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	for _, special := range []string{",", "=", " "} {
+		value = strings.Replace(value, special, "\\"+special, -1)
+	}
+	return value
+}
+
+// Replaces characters not allowed in Graphite tag value.
+func escapeGraphite(value string) string {
+	for _, special := range []string{";", "~", " "} {
+		value = strings.Replace(value, special, "_", -1)
+	}
+	return value
+}
+
+// Replaces characters not allowed in StatsD tag value.
+func escapeStatsD(value string) string {
+	for _, special := range []string{",", "|", "#", " ", ":"} {
+		value = strings.Replace(value, special, "_", -1)
+	}
+	return value
+}
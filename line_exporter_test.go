@@ -0,0 +1,228 @@
+package container_monitor
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatLine(t *testing.T) {
+	point := &metricPoint{
+		Name:  "process_cpu_percent",
+		Tags:  []string{"pid", "7", "name", "media server"},
+		Value: 12.5,
+	}
+	now := time.Unix(1500000000, 0)
+	for _, test := range []struct {
+		format string
+		tags   []string
+		line   string
+	}{
+		{FORMAT_INFLUX, []string{"host", "h 1", "test_id", "a,b=c"},
+			`container_monitor,host=h\ 1,test_id=a\,b\=c,pid=7,` +
+				`name=media\ server process_cpu_percent=12.5 ` +
+				`1500000000000000000`},
+		{FORMAT_INFLUX, []string{"host", "h", "test_id", ""},
+			`container_monitor,host=h,pid=7,name=media\ server ` +
+				`process_cpu_percent=12.5 1500000000000000000`},
+		{FORMAT_GRAPHITE, []string{"host", "h 1", "test_id", "a,b=c"},
+			"container_monitor.process_cpu_percent;host=h_1;" +
+				"test_id=a,b=c;pid=7;name=media_server 12.5 1500000000"},
+		{FORMAT_GRAPHITE, []string{"host", "h", "test_id", ""},
+			"container_monitor.process_cpu_percent;host=h;pid=7;" +
+				"name=media_server 12.5 1500000000"},
+		{FORMAT_STATSD, []string{"host", "h 1", "test_id", "a,b=c"},
+			"container_monitor.process_cpu_percent:12.5|g|" +
+				"#host:h_1,test_id:a_b=c,pid:7,name:media_server"},
+		{FORMAT_STATSD, []string{"host", "h", "test_id", ""},
+			"container_monitor.process_cpu_percent:12.5|g|" +
+				"#host:h,pid:7,name:media_server"},
+	} {
+		exporter := &LineExporter{format: test.format}
+		line := exporter.formatLine(point, test.tags, now)
+		if line != test.line {
+			t.Errorf("%s line is\n%s\nwant\n%s", test.format, line, test.line)
+		}
+	}
+}
+
+func TestEscapeInflux(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain":        "plain",
+		"with space":   `with\ space`,
+		"a,b":          `a\,b`,
+		"a=b":          `a\=b`,
+		`back\slash`:   `back\\slash`,
+		"a, b=c":       `a\,\ b\=c`,
+		`end\ , =`:     `end\\\ \,\ \=`,
+		"":             "",
+		"unicode €, x": `unicode\ €\,\ x`,
+	} {
+		if got := escapeInflux(value); got != want {
+			t.Errorf("escapeInflux(%q) is %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestEscapeGraphite(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain":      "plain",
+		"with space": "with_space",
+		"a;b":        "a_b",
+		"~a":         "_a",
+		"a,b=c":      "a,b=c",
+	} {
+		if got := escapeGraphite(value); got != want {
+			t.Errorf("escapeGraphite(%q) is %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestEscapeStatsD(t *testing.T) {
+	for value, want := range map[string]string{
+		"plain":      "plain",
+		"with space": "with_space",
+		"a,b":        "a_b",
+		"a|b#c:d":    "a_b_c_d",
+		"a=b":        "a=b",
+	} {
+		if got := escapeStatsD(value); got != want {
+			t.Errorf("escapeStatsD(%q) is %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestSplitDatagrams(t *testing.T) {
+	line := strings.Repeat("x", 99)
+	for _, test := range []struct {
+		lines     []string
+		datagrams []int // Count of lines in every datagram.
+	}{
+		{nil, []int{}},
+		{[]string{line}, []int{1}},
+		// 14 lines of 100 bytes with separators fit into 1400 bytes.
+		{repeatLine(line, 14), []int{14}},
+		{repeatLine(line, 15), []int{14, 1}},
+		{repeatLine(line, 30), []int{14, 14, 2}},
+		{[]string{strings.Repeat("y", 2000), line},
+			[]int{1, 1}},
+	} {
+		datagrams := splitDatagrams(test.lines, MAX_DATAGRAM_LENGTH)
+		if len(datagrams) != len(test.datagrams) {
+			t.Errorf("%d lines are split to %d datagrams, want %d",
+				len(test.lines), len(datagrams), len(test.datagrams))
+			continue
+		}
+		joined := []string{}
+		for i, datagram := range datagrams {
+			lines := strings.Split(datagram, "\n")
+			if len(lines) != test.datagrams[i] {
+				t.Errorf("datagram %d has %d lines, want %d",
+					i, len(lines), test.datagrams[i])
+			}
+			if len(lines) > 1 && len(datagram) > MAX_DATAGRAM_LENGTH {
+				t.Errorf("datagram %d has %d bytes", i, len(datagram))
+			}
+			joined = append(joined, lines...)
+		}
+		if strings.Join(joined, "\n") != strings.Join(test.lines, "\n") {
+			t.Errorf("lines of %d datagrams are changed", len(datagrams))
+		}
+	}
+}
+
+// Returns count copies of the line.
+func repeatLine(line string, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = line
+	}
+	return lines
+}
+
+func TestLineExporterKeepsTCPConnection(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	exporter, err := NewLineExporter(
+		FORMAT_GRAPHITE, "tcp://"+listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	sample := &Sample{TestID: "load-1", Time: time.Unix(1500000000, 0),
+		CPULimit: 2}
+	for i := 0; i < 2; i++ {
+		err = exporter.Write(sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn := <-accepted
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(line, "container_monitor.cpu_limit;") {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+	select {
+	case <-accepted:
+		t.Error("second connection is dialed")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestLineExporterSendsDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	exporter, err := NewLineExporter(
+		FORMAT_STATSD, "udp://"+conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Close()
+	sample := newTestSample()
+	for i := 0; i < 50; i++ {
+		sample.Processes = append(sample.Processes, sample.Processes[0])
+	}
+	err = exporter.Write(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buffer := make([]byte, 65536)
+	lines := 0
+	for lines < len(metricPoints(sample)) {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n > MAX_DATAGRAM_LENGTH {
+			t.Errorf("datagram has %d bytes", n)
+		}
+		lines += len(strings.Split(string(buffer[:n]), "\n"))
+	}
+}
@@ -1,46 +1,160 @@
 package container_monitor
 
 import (
-	"gopkg.in/redis.v4"
 	"log"
+	"sync"
 	"time"
 )
 
-// Container monitor struct. This monitor listens unix socket
-// and writes to socket system info from container where this running.
+const (
+	STOP_REASON_STOPPED     = "stopped"     // Stopped by the stress client.
+	STOP_REASON_TIMEOUT     = "timeout"     // Max duration of the test passed.
+	STOP_REASON_CLIENT_LOST = "client lost" // No keepalive from the client.
+)
+
+// Container monitor struct. This monitor receives samples of the container
+// system info from the sampler and writes them to the sink for one test.
 type ContainerMonitor struct {
-	close_channel chan bool          // Channel for close signal.
-	info_factory  *SystemInfoFactory // System info factory.
-	testID        string
+	close_channel chan bool     // Channel for close signal.
+	done_channel  chan bool     // Closed when the monitor has finished.
+	alive_channel chan bool     // Channel for keepalive signal.
+	close_once    sync.Once     // Guards closing of the close channel.
+	sink          TestSink      // Sink of samples.
+	sampler       *Sampler      // Shared sampler.
+	filter        *sampleFilter // Test options.
+	testID        string        // Test ID.
+	started_at    time.Time     // Test start time.
+	samples       int64         // Count of samples sent to the sink.
+	paused        bool          // Samples are not written while paused.
+	lock          sync.Mutex    // Status lock.
 }
 
 // Returns new ContainerMonitor instance.
 //
-// params: client *redis.Client   Instance of Redis client.
-//         test_id string         Test ID.
-func newContainerMonitor(client *redis.Client, test_id string) *ContainerMonitor {
+// params: sink    TestSink        Sink of samples.
+//         sampler *Sampler        Shared sampler.
+//         test_id string          Test ID.
+//         filter  *sampleFilter   Test options.
+func newContainerMonitor(sink TestSink, sampler *Sampler,
+	test_id string, filter *sampleFilter) *ContainerMonitor {
 	return &ContainerMonitor{
 		close_channel: make(chan bool),
-		info_factory:  NewSystemInfoFactory(client),
+		done_channel:  make(chan bool),
+		alive_channel: make(chan bool, 1),
+		sink:          sink,
+		sampler:       sampler,
+		filter:        filter,
 		testID:        test_id,
+		started_at:    time.Now(),
 	}
 }
 
 // Runs the container monitor.
-// Just starts listen of unix socket.
+// Writes every sample of the sampler until the monitor is stopped, the
+// maximal duration of the test has passed or the stress client is lost.
+// The stop reason is stored with the test data.
 func (m *ContainerMonitor) Run() {
+	defer close(m.done_channel)
+	err := m.sink.StartTest(m.testID)
+	if err != nil {
+		log.Printf("can not start test %s: %s", m.testID, err.Error())
+	}
+	reason := m.collect()
+	log.Printf("test %s ended: %s", m.testID, reason)
+	err = m.sink.EndTest(m.testID, reason)
+	if err != nil {
+		log.Printf("can not stop test %s: %s", m.testID, err.Error())
+	}
+}
+
+// Writes samples until the test ends.
+//
+// return: Stop reason.
+func (m *ContainerMonitor) collect() string {
+	samples := m.sampler.Subscribe(m.testID, m.filter)
+	defer m.sampler.Unsubscribe(m.testID)
+	var timeout <-chan time.Time
+	if m.filter.max_duration > 0 {
+		timeout = time.After(m.filter.max_duration)
+	}
+	var watchdog *time.Timer
+	var lost <-chan time.Time
+	if m.filter.keepalive > 0 {
+		watchdog = time.NewTimer(m.filter.keepalive)
+		defer watchdog.Stop()
+		lost = watchdog.C
+	}
 	for {
 		select {
-		case <-time.After(time.Second * 2):
-			m.info_factory.UpdateSystemInfo(m.testID)
+		case sample := <-samples:
+			m.write(sample)
+		case <-m.alive_channel:
+			if watchdog != nil && watchdog.Stop() {
+				watchdog.Reset(m.filter.keepalive)
+			}
+		case <-timeout:
+			return STOP_REASON_TIMEOUT
+		case <-lost:
+			return STOP_REASON_CLIENT_LOST
 		case <-m.close_channel:
-			return
+			return STOP_REASON_STOPPED
 		}
 	}
 }
 
-// Close redis connection.
+// Stops the monitor and waits until it has finished.
 func (m *ContainerMonitor) Stop() {
-	log.Println("stop test: %s", m.testID)
-	m.close_channel <- true
+	log.Printf("stop test: %s", m.testID)
+	m.close_once.Do(func() {
+		close(m.close_channel)
+	})
+	<-m.done_channel
+}
+
+// Resets the inactivity watchdog of the test. Keepalives are ignored if the
+// test has no watchdog.
+func (m *ContainerMonitor) Keepalive() {
+	select {
+	case m.alive_channel <- true:
+	default:
+	}
+}
+
+// Pauses or resumes writing of samples, for example to exclude a ramp-up
+// window from the test.
+//
+// param: paused bool   True to pause, false to resume.
+func (m *ContainerMonitor) SetPaused(paused bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.paused = paused
+}
+
+// Returns status of the test.
+func (m *ContainerMonitor) Status() *TestStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return &TestStatus{
+		TestID:    m.testID,
+		Paused:    m.paused,
+		StartedAt: m.started_at,
+		Uptime:    time.Since(m.started_at),
+		Samples:   m.samples,
+	}
+}
+
+// Sends the sample to the sink unless the monitor is paused. The sink is
+// called without the status lock, so a slow sink never blocks Status.
+func (m *ContainerMonitor) write(sample *Sample) {
+	m.lock.Lock()
+	paused := m.paused
+	if !paused {
+		m.samples++
+	}
+	m.lock.Unlock()
+	if paused {
+		return
+	}
+	sample.TestID = m.testID
+	m.sink.Write(sample)
 }
@@ -0,0 +1,249 @@
+package container_monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OTLP_SCOPE        = "go-container-monitor" // Instrumentation scope name.
+	OTLP_TIMEOUT      = time.Second * 10       // Export request timeout.
+	OTLP_METRICS_PATH = "/v1/metrics"          // Default OTLP/HTTP metrics path.
+	OTLP_CONTENT_TYPE = "application/json"     // OTLP/HTTP JSON encoding.
+	OTLP_METRIC_PREF  = "container_monitor."   // Prefix of metric names.
+)
+
+// OTLP/HTTP JSON export request. Only the gauge part of the OTLP metrics
+// data model is used.
+type otlpRequest struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+// Metrics of one resource.
+type otlpResourceMetrics struct {
+	Resource     *otlpResource       `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+// Resource described by attributes.
+type otlpResource struct {
+	Attributes []*otlpAttribute `json:"attributes"`
+}
+
+// Metrics of one instrumentation scope.
+type otlpScopeMetrics struct {
+	Scope   *otlpScope    `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+// Instrumentation scope.
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+// Gauge metric.
+type otlpMetric struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description,omitempty"`
+	Unit        string     `json:"unit,omitempty"`
+	Gauge       *otlpGauge `json:"gauge"`
+}
+
+// Gauge data points.
+type otlpGauge struct {
+	DataPoints []*otlpDataPoint `json:"dataPoints"`
+}
+
+// Gauge value at the sample time.
+type otlpDataPoint struct {
+	Attributes   []*otlpAttribute `json:"attributes,omitempty"`
+	TimeUnixNano string           `json:"timeUnixNano"`
+	AsDouble     float64          `json:"asDouble"`
+}
+
+// Key and string value attribute.
+type otlpAttribute struct {
+	Key   string           `json:"key"`
+	Value *otlpStringValue `json:"value"`
+}
+
+// String attribute value.
+type otlpStringValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// Sink which sends samples over OTLP/HTTP with JSON encoding to
+// OpenTelemetry collector. Resource attributes are the container ID, the
+// hostname and the test ID.
+type OTLPExporter struct {
+	endpoint     string       // OTLP/HTTP metrics URL.
+	client       *http.Client // HTTP client.
+	container_id string       // Container ID resource attribute.
+	host_name    string       // Hostname resource attribute.
+}
+
+// Returns new instance of OTLP exporter.
+//
+// param: endpoint string   Collector URL, for example "http://localhost:4318".
+//                          OTLP_METRICS_PATH is added if the URL has no path.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	host_name, err := os.Hostname()
+	if err != nil {
+		log.Printf("can not get hostname: %s", err.Error())
+	}
+	return &OTLPExporter{
+		endpoint:     otlpURL(endpoint),
+		client:       &http.Client{Timeout: OTLP_TIMEOUT},
+		container_id: ContainerID(),
+		host_name:    host_name,
+	}
+}
+
+// Returns metrics URL of the endpoint.
+func otlpURL(endpoint string) string {
+	parsed, err := url.Parse(endpoint)
+	if err == nil && (parsed.Path == "" || parsed.Path == "/") {
+		return strings.TrimSuffix(endpoint, "/") + OTLP_METRICS_PATH
+	}
+	return endpoint
+}
+
+// Sends the sample to the collector.
+//
+// param: sample *Sample   Collected sample.
+func (e *OTLPExporter) Write(sample *Sample) error {
+	data, err := json.Marshal(e.request(sample))
+	if err != nil {
+		return err
+	}
+	response, err := e.client.Post(
+		e.endpoint, OTLP_CONTENT_TYPE, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("OTLP endpoint replied %s", response.Status)
+	}
+	return nil
+}
+
+// Closes the exporter. Requests are not kept open, nothing to release.
+func (e *OTLPExporter) Close() error {
+	return nil
+}
+
+// Returns export request of the sample.
+//
+// param: sample *Sample   Collected sample.
+func (e *OTLPExporter) request(sample *Sample) *otlpRequest {
+	attributes := []*otlpAttribute{
+		newOTLPAttribute("service.name", OTLP_SCOPE),
+		newOTLPAttribute("host.name", e.host_name),
+	}
+	if e.container_id != "" {
+		attributes = append(attributes,
+			newOTLPAttribute("container.id", e.container_id))
+	}
+	if sample.TestID != "" {
+		attributes = append(attributes,
+			newOTLPAttribute("test.id", sample.TestID))
+	}
+	return &otlpRequest{ResourceMetrics: []*otlpResourceMetrics{{
+		Resource: &otlpResource{Attributes: attributes},
+		ScopeMetrics: []*otlpScopeMetrics{{
+			Scope:   &otlpScope{Name: OTLP_SCOPE},
+			Metrics: otlpMetrics(sample),
+		}},
+	}}}
+}
+
+// Returns gauges of the sample.
+//
+// param: sample *Sample   Collected sample.
+func otlpMetrics(sample *Sample) []*otlpMetric {
+	time_nano := strconv.FormatInt(sample.Time.UnixNano(), 10)
+	gauge := func(name string, unit string, description string,
+		points ...*otlpDataPoint) *otlpMetric {
+		for _, point := range points {
+			point.TimeUnixNano = time_nano
+		}
+		return &otlpMetric{
+			Name:        OTLP_METRIC_PREF + name,
+			Description: description,
+			Unit:        unit,
+			Gauge:       &otlpGauge{DataPoints: points},
+		}
+	}
+	metrics := []*otlpMetric{gauge("cpu.limit", "{cpu}",
+		"Effective CPU cores of the container.",
+		&otlpDataPoint{AsDouble: sample.CPULimit})}
+	if sample.Has(COLLECTOR_CPU) {
+		metrics = append(metrics, gauge("cpu.usage", "%",
+			"CPU usage in percents of the CPU limit.",
+			&otlpDataPoint{AsDouble: sample.CPUusage}))
+	}
+	if sample.Has(COLLECTOR_MEMORY) && sample.VirtualMemory != nil {
+		metrics = append(metrics,
+			gauge("memory.total", "By", "Total memory.", &otlpDataPoint{
+				AsDouble: float64(sample.VirtualMemory.Total)}),
+			gauge("memory.used", "By", "Used memory.", &otlpDataPoint{
+				AsDouble: float64(sample.VirtualMemory.Used)}),
+			gauge("memory.utilization", "%", "Used memory in percents.",
+				&otlpDataPoint{AsDouble: sample.VirtualMemory.UsedPercent}))
+	}
+	if sample.Has(COLLECTOR_SWAP) && sample.SWAPmemory != nil {
+		metrics = append(metrics,
+			gauge("swap.total", "By", "Total swap.", &otlpDataPoint{
+				AsDouble: float64(sample.SWAPmemory.Total)}),
+			gauge("swap.used", "By", "Used swap.", &otlpDataPoint{
+				AsDouble: float64(sample.SWAPmemory.Used)}),
+			gauge("swap.utilization", "%", "Used swap in percents.",
+				&otlpDataPoint{AsDouble: sample.SWAPmemory.UsedPercent}))
+	}
+	if !sample.Has(COLLECTOR_PROCESSES) || len(sample.Processes) == 0 {
+		return metrics
+	}
+	cpu_points := []*otlpDataPoint{}
+	rss_points := []*otlpDataPoint{}
+	thread_points := []*otlpDataPoint{}
+	for _, process := range sample.Processes {
+		attributes := []*otlpAttribute{
+			newOTLPAttribute("process.pid", strconv.Itoa(int(process.PID))),
+			newOTLPAttribute("process.executable.name", process.Name),
+		}
+		cpu_points = append(cpu_points, &otlpDataPoint{
+			Attributes: attributes, AsDouble: process.CPUPercent})
+		if process.MemoryInfo != nil {
+			rss_points = append(rss_points, &otlpDataPoint{
+				Attributes: attributes,
+				AsDouble:   float64(process.MemoryInfo.RSS)})
+		}
+		thread_points = append(thread_points, &otlpDataPoint{
+			Attributes: attributes, AsDouble: float64(process.NumThreads)})
+	}
+	return append(metrics,
+		gauge("process.cpu.usage", "%",
+			"CPU usage of the process in percents of the CPU limit.",
+			cpu_points...),
+		gauge("process.memory.rss", "By",
+			"Resident memory of the process.", rss_points...),
+		gauge("process.threads", "{thread}",
+			"Threads of the process.", thread_points...))
+}
+
+// Returns string attribute.
+func newOTLPAttribute(key string, value string) *otlpAttribute {
+	return &otlpAttribute{Key: key, Value: &otlpStringValue{StringValue: value}}
+}
@@ -0,0 +1,150 @@
+package container_monitor
+
+import (
+	"encoding/json"
+	"github.com/shirou/gopsutil/mem"
+	"github.com/shirou/gopsutil/process"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Returns sample with all collectors and one process.
+func newTestSample() *Sample {
+	return &Sample{
+		TestID:   "load-1",
+		Time:     time.Unix(1500000000, 0),
+		CPUusage: 42.5,
+		CPULimit: 2,
+		VirtualMemory: &mem.VirtualMemoryStat{
+			Total: 1000, Used: 250, UsedPercent: 25},
+		SWAPmemory: &mem.SwapMemoryStat{Total: 100, Used: 10, UsedPercent: 10},
+		Processes: []*ProcessSample{{
+			PID:        7,
+			Name:       "media server",
+			CPUPercent: 12.5,
+			NumThreads: 3,
+			MemoryInfo: &process.MemoryInfoStat{RSS: 4096},
+		}},
+		Collectors: ALL_COLLECTORS,
+	}
+}
+
+// Returns string attributes by key.
+func otlpAttributes(attributes []*otlpAttribute) map[string]string {
+	values := make(map[string]string)
+	for _, attribute := range attributes {
+		values[attribute.Key] = attribute.Value.StringValue
+	}
+	return values
+}
+
+func TestOTLPExporterWrite(t *testing.T) {
+	requests := make(chan *otlpRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != OTLP_METRICS_PATH {
+				t.Errorf("path is %q, want %q", r.URL.Path, OTLP_METRICS_PATH)
+			}
+			if r.Header.Get("Content-Type") != OTLP_CONTENT_TYPE {
+				t.Errorf("content type is %q", r.Header.Get("Content-Type"))
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			request := &otlpRequest{}
+			err = json.Unmarshal(body, request)
+			if err != nil {
+				t.Error(err)
+			}
+			requests <- request
+		}))
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL)
+	exporter.container_id = "0123456789ab"
+	sample := newTestSample()
+	err := exporter.Write(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+
+	if len(request.ResourceMetrics) != 1 {
+		t.Fatalf("got %d resources, want 1", len(request.ResourceMetrics))
+	}
+	resource := request.ResourceMetrics[0]
+	host_name, _ := os.Hostname()
+	attributes := otlpAttributes(resource.Resource.Attributes)
+	for key, want := range map[string]string{
+		"service.name": OTLP_SCOPE,
+		"host.name":    host_name,
+		"container.id": "0123456789ab",
+		"test.id":      "load-1",
+	} {
+		if attributes[key] != want {
+			t.Errorf("attribute %s is %q, want %q", key, attributes[key], want)
+		}
+	}
+
+	metrics := make(map[string]*otlpMetric)
+	for _, metric := range resource.ScopeMetrics[0].Metrics {
+		metrics[metric.Name] = metric
+	}
+	time_nano := strconv.FormatInt(sample.Time.UnixNano(), 10)
+	for name, want := range map[string]float64{
+		"cpu.limit":          2,
+		"cpu.usage":          42.5,
+		"memory.total":       1000,
+		"memory.used":        250,
+		"memory.utilization": 25,
+		"swap.total":         100,
+		"swap.used":          10,
+		"swap.utilization":   10,
+		"process.cpu.usage":  12.5,
+		"process.memory.rss": 4096,
+		"process.threads":    3,
+	} {
+		metric, ok := metrics[OTLP_METRIC_PREF+name]
+		if !ok {
+			t.Errorf("metric %s is missing", name)
+			continue
+		}
+		points := metric.Gauge.DataPoints
+		if len(points) != 1 {
+			t.Errorf("metric %s has %d points, want 1", name, len(points))
+			continue
+		}
+		if points[0].AsDouble != want {
+			t.Errorf("metric %s is %v, want %v", name, points[0].AsDouble, want)
+		}
+		if points[0].TimeUnixNano != time_nano {
+			t.Errorf("metric %s time is %s, want %s",
+				name, points[0].TimeUnixNano, time_nano)
+		}
+	}
+	process_attributes := otlpAttributes(metrics[OTLP_METRIC_PREF+
+		"process.cpu.usage"].Gauge.DataPoints[0].Attributes)
+	if process_attributes["process.pid"] != "7" ||
+		process_attributes["process.executable.name"] != "media server" {
+		t.Errorf("process attributes are %v", process_attributes)
+	}
+}
+
+func TestOTLPExporterWriteError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	defer server.Close()
+
+	err := NewOTLPExporter(server.URL).Write(newTestSample())
+	if err == nil {
+		t.Fatal("error of unavailable collector is not returned")
+	}
+}
@@ -0,0 +1,210 @@
+package container_monitor
+
+import (
+	"fmt"
+	"github.com/shirou/gopsutil/process"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PROCESS_WORKERS       = 8           // Default count of process collector workers.
+	PROCESS_SAMPLE_WINDOW = time.Second // Default CPU measurement window.
+)
+
+// Values of a single process collected during one sample.
+type ProcessSample struct {
+	PID           int32                   // Process system ID.
+	Name          string                  // Process name.
+	Status        string                  // Process status info.
+	Cwd           string                  // Process file path.
+	CreateTime    int64                   // Process creation time.
+	MemoryInfo    *process.MemoryInfoStat // Process memory usage info.
+	MemoryPercent float64                 // Resident memory in percents of memory limit.
+	NumThreads    int32                   // Process threads count.
+	CPUPercent    float64                 // CPU usage in percents of CPU limit.
+}
+
+// Returns process identity. A PID can be reused by the system during a long
+// test, so the process is identified by PID and creation time.
+func (s *ProcessSample) Key() string {
+	return fmt.Sprintf("%v-%v", s.PID, s.CreateTime)
+}
+
+// Returns PID and creation time from the process key. Keys written before
+// the creation time was a part of the identity contain PID only.
+//
+// param: process_key string   Process key.
+func parseProcessKey(process_key string) (int32, int64, error) {
+	parts := strings.SplitN(process_key, "-", 2)
+	pid, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return int32(pid), 0, nil
+	}
+	create_time, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int32(pid), create_time, nil
+}
+
+// Collects information about all processes. CPU times of all processes are
+// taken at the beginning and at the end of one shared window, so the time of
+// a sample does not depend on the count of processes.
+type ProcessCollector struct {
+	workers int // Count of concurrent workers.
+}
+
+// Returns new instance of process collector.
+//
+// param: workers int   Count of concurrent workers.
+func NewProcessCollector(workers int) *ProcessCollector {
+	if workers < 1 {
+		workers = 1
+	}
+	return &ProcessCollector{
+		workers: workers,
+	}
+}
+
+// Returns info of all running processes.
+// Processes which exit during the window are skipped.
+//
+// param: window time.Duration   CPU measurement window.
+func (c *ProcessCollector) Collect(window time.Duration) []*ProcessSample {
+	pids, err := process.Pids()
+	if err != nil {
+		log.Printf("can not get top: %s", err.Error())
+		return nil
+	}
+
+	processes := make([]*process.Process, len(pids))
+	start_times := make([]float64, len(pids))
+	c.forEach(len(pids), func(i int) {
+		proc, err := process.NewProcess(pids[i])
+		if err != nil {
+			log.Printf("can not get process ID %v info: %s", pids[i], err.Error())
+			return
+		}
+		times, err := proc.Times()
+		if err != nil {
+			log.Printf(
+				"can not get process ID %v cpu times: %s", pids[i], err.Error())
+			return
+		}
+		processes[i] = proc
+		start_times[i] = times.Total()
+	})
+	start := time.Now()
+	time.Sleep(window)
+
+	samples := make([]*ProcessSample, len(pids))
+	c.forEach(len(pids), func(i int) {
+		if processes[i] == nil {
+			return
+		}
+		times, err := processes[i].Times()
+		if err != nil {
+			log.Printf(
+				"can not get process ID %v cpu times: %s", pids[i], err.Error())
+			return
+		}
+		sample := c.describe(processes[i])
+		elapsed := time.Since(start).Seconds()
+		if elapsed > 0 && times.Total() >= start_times[i] {
+			sample.CPUPercent = (times.Total() - start_times[i]) / elapsed * 100
+		}
+		samples[i] = sample
+	})
+
+	result := make([]*ProcessSample, 0, len(samples))
+	for _, sample := range samples {
+		if sample != nil {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
+
+// Returns process info without CPU and memory usage percents.
+//
+// param: proc *process.Process   The process.
+func (c *ProcessCollector) describe(proc *process.Process) *ProcessSample {
+	pid := proc.Pid
+	sample := &ProcessSample{PID: pid}
+
+	name, err := proc.Name()
+	if err != nil {
+		log.Printf("can not get process ID %v name: %s", pid, err.Error())
+		name = "undefined"
+	}
+	sample.Name = name
+
+	status, err := proc.Status()
+	if err != nil {
+		log.Printf("can not get process ID  %v status: %s", pid, err.Error())
+		status = "undefined"
+	}
+	sample.Status = status
+
+	cwd, err := proc.Cwd()
+	if err != nil {
+		cwd = err.Error()
+		log.Printf("can not get process ID %v cwd: %s", pid, err.Error())
+	}
+	sample.Cwd = cwd
+
+	create_time, err := proc.CreateTime()
+	if err != nil {
+		create_time = time.Unix(0, 0).Unix()
+		log.Printf(
+			"can not get process ID %v creation time: %s", pid, err.Error())
+	}
+	sample.CreateTime = create_time
+
+	memory_info, err := proc.MemoryInfo()
+	if err != nil {
+		log.Printf(
+			"can not get process ID %v memory info: %s", pid, err.Error())
+		memory_info = &process.MemoryInfoStat{}
+	}
+	sample.MemoryInfo = memory_info
+
+	num_threads, err := proc.NumThreads()
+	if err != nil {
+		log.Printf(
+			"can not get process ID %v num threads: %s", pid, err.Error())
+	}
+	sample.NumThreads = num_threads
+	return sample
+}
+
+// Calls fn for every index from 0 to count on the bounded worker pool and
+// waits until all calls are done.
+//
+// params: count int             Count of items.
+//         fn    func(i int)     Function called for each item index.
+func (c *ProcessCollector) forEach(count int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < c.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
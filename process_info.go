@@ -2,17 +2,31 @@ package container_monitor
 
 import (
 	"github.com/shirou/gopsutil/process"
+	"time"
 )
 
+// Process hash fields stored for every process of the test.
+var PROCESS_FIELDS = []string{
+	"names", "status", "cwd", "creation_time", "memory_info", "num_threads",
+	"mem_percent", "cpu_percent", "samples", "first_seen", "last_seen",
+}
+
 // Process info value object
 type ProcessInfo struct {
-	Name          string                  // Process name.
-	PID           int32                   // Process system ID
-	Status        string                  // Process status info.
-	Cwd           string                  // Process file path.
-	CreateTime    string                  // Process creation UNIX time.
-	MemoryInfo    *process.MemoryInfoStat // Process memory usage info.
-	MemoryPercent float64                 // Usage virtual memory in percents.
-	NumThreads    int64                   // Process threads count.
-	CPUPercent    float64                 // CPU usage in percents.
+	Key              string                  // Process identity: PID and creation time.
+	Name             string                  // Process name.
+	PID              int32                   // Process system ID
+	Status           string                  // Process status info.
+	Cwd              string                  // Process file path.
+	CreateTime       string                  // Process creation UNIX time.
+	MemoryInfo       *process.MemoryInfoStat // Process memory usage info.
+	MemoryPercent    float64                 // Resident memory in percents of memory limit.
+	NumThreads       int64                   // Process threads count.
+	CPUPercent       float64                 // CPU usage in percents of CPU limit.
+	CPUStatistics    *Statistics             // CPU usage statistics.
+	MemoryStatistics *Statistics             // Memory usage statistics.
+	Samples          int64                   // Count of samples with the process.
+	FirstSeen        string                  // Time of the first sample with the process.
+	LastSeen         string                  // Time of the last sample with the process.
+	Lifetime         time.Duration           // Time between the first and the last sample.
 }
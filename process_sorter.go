@@ -26,3 +26,24 @@ func (b ByCPU) Less(i, j int) bool {
 	}
 	return b[i].MemoryPercent > b[j].MemoryPercent
 }
+
+// Sorts process samples by CPU usage.
+type ProcessSamplesByCPU []*ProcessSample
+
+// Returns length of sortable array.
+func (b ProcessSamplesByCPU) Len() int {
+	return len(b)
+}
+
+// Swaps array indexes.
+func (b ProcessSamplesByCPU) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// Returns sort rule.
+func (b ProcessSamplesByCPU) Less(i, j int) bool {
+	if b[i].CPUPercent > 0 || b[j].CPUPercent > 0 {
+		return b[i].CPUPercent > b[j].CPUPercent
+	}
+	return b[i].MemoryPercent > b[j].MemoryPercent
+}
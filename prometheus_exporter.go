@@ -0,0 +1,245 @@
+package container_monitor
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	METRICS_SUBSCRIBER    = "\x00prometheus"     // Sampler subscriber ID of the exporter.
+	METRICS_PREFIX        = "container_monitor_" // Prefix of metric names.
+	MAX_METRICS_PROCESSES = 50                   // Maximal count of processes with metrics.
+)
+
+// Prometheus gauge of a metric point.
+type prometheusGauge struct {
+	Point string // Name of metric point.
+	Name  string // Gauge name without prefix.
+	Help  string // Gauge description.
+}
+
+// Gauges in the order of exposition.
+var PROMETHEUS_GAUGES = []*prometheusGauge{
+	{"sample_time", "sample_timestamp_seconds", "Time of the latest sample."},
+	{"cpu_limit", "cpu_limit_cores", "Effective CPU cores of the container."},
+	{"cpu_usage", "cpu_usage_percent",
+		"CPU usage in percents of the CPU limit."},
+	{"memory_total", "memory_total_bytes", "Total memory."},
+	{"memory_used", "memory_used_bytes", "Used memory."},
+	{"memory_percent", "memory_used_percent", "Used memory in percents."},
+	{"swap_total", "swap_total_bytes", "Total swap."},
+	{"swap_used", "swap_used_bytes", "Used swap."},
+	{"swap_percent", "swap_used_percent", "Used swap in percents."},
+	{"process_cpu_percent", "process_cpu_percent",
+		"CPU usage of the process in percents of the CPU limit."},
+	{"process_rss", "process_rss_bytes", "Resident memory of the process."},
+	{"process_threads", "process_threads", "Threads of the process."},
+}
+
+// Sink which exposes the latest samples as Prometheus gauges in text
+// exposition format. Gauges of a running test are labeled with its ID.
+// Live gauges without test ID are optional, see Run.
+// Process gauges are limited to MAX_METRICS_PROCESSES processes with the
+// highest CPU usage.
+type PrometheusExporter struct {
+	controller    *Controller        // Control commands handler.
+	samples       map[string]*Sample // Latest samples by test ID.
+	lock          sync.Mutex         // Samples lock.
+	close_channel chan bool          // Channel for close signal.
+}
+
+// Returns new instance of Prometheus exporter.
+//
+// param: controller *Controller   Controller of the shared sampler.
+func NewPrometheusExporter(controller *Controller) *PrometheusExporter {
+	return &PrometheusExporter{
+		controller:    controller,
+		samples:       make(map[string]*Sample),
+		close_channel: make(chan bool),
+	}
+}
+
+// Receives samples of the sampler until the exporter is stopped, so the
+// gauges without test ID are live even if no test is running. The sampler
+// never goes idle while the exporter runs, and tests share its samples, so
+// the options should be cheap, for example a long interval.
+//
+// param: options *TestOptions   Sampling options, nil for defaults.
+func (e *PrometheusExporter) Run(options *TestOptions) error {
+	filter, err := newExporterFilter(options)
+	if err != nil {
+		return err
+	}
+	sampler := e.controller.sampler
+	samples := sampler.Subscribe(METRICS_SUBSCRIBER, filter)
+	defer sampler.Unsubscribe(METRICS_SUBSCRIBER)
+	for {
+		select {
+		case sample := <-samples:
+			e.Write(sample)
+		case <-e.close_channel:
+			return nil
+		}
+	}
+}
+
+// Returns sample filter of metrics exporter. The count of processes is
+// limited to MAX_METRICS_PROCESSES to keep the count of series low.
+//
+// param: options *TestOptions   Sampling options, nil for defaults.
+func newExporterFilter(options *TestOptions) (*sampleFilter, error) {
+	metrics_options := TestOptions{}
+	if options != nil {
+		metrics_options = *options
+	}
+	if metrics_options.Top == 0 || metrics_options.Top > MAX_METRICS_PROCESSES {
+		metrics_options.Top = MAX_METRICS_PROCESSES
+	}
+	return newSampleFilter(&metrics_options)
+}
+
+// Stops the exporter.
+func (e *PrometheusExporter) Stop() {
+	close(e.close_channel)
+}
+
+// Keeps the sample as the latest sample of its test.
+//
+// param: sample *Sample   Collected sample.
+func (e *PrometheusExporter) Write(sample *Sample) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.samples[sample.TestID] = sample
+	return nil
+}
+
+// Does nothing, gauges of the test appear with its first sample.
+func (e *PrometheusExporter) StartTest(test_id string) error {
+	return nil
+}
+
+// Removes gauges of the ended test.
+func (e *PrometheusExporter) EndTest(test_id string, reason string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.samples, test_id)
+	return nil
+}
+
+// Closes the exporter. The gauges are kept for the last scrape.
+func (e *PrometheusExporter) Close() error {
+	return nil
+}
+
+// Writes the gauges of the latest samples.
+func (e *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.lock.Lock()
+	samples := make([]*Sample, 0, len(e.samples))
+	for _, sample := range e.samples {
+		samples = append(samples, sample)
+	}
+	e.lock.Unlock()
+	statuses, _ := e.controller.status("")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(formatPrometheus(samples, statuses))
+}
+
+// Returns the samples and running tests in Prometheus text format.
+//
+// params: samples  []*Sample      Latest samples of tests.
+//         statuses []*TestStatus   Running tests.
+func formatPrometheus(samples []*Sample, statuses []*TestStatus) []byte {
+	buffer := &bytes.Buffer{}
+	writeGaugeHeader(buffer, "test_info", "Running stress test.")
+	for _, status := range statuses {
+		writeGauge(buffer, "test_info", 1, "test_id", status.TestID)
+	}
+	sort.Sort(SamplesByTestID(samples))
+	names := make(map[string]string)
+	for _, gauge := range PROMETHEUS_GAUGES {
+		names[gauge.Point] = gauge.Name
+	}
+	lines := make(map[string]*bytes.Buffer)
+	for _, sample := range samples {
+		points := append([]*metricPoint{{Name: "sample_time",
+			Value: float64(sample.Time.UnixNano()) / 1e9}},
+			metricPoints(topProcesses(sample, MAX_METRICS_PROCESSES))...)
+		for _, point := range points {
+			name, ok := names[point.Name]
+			if !ok {
+				continue
+			}
+			if lines[point.Name] == nil {
+				lines[point.Name] = &bytes.Buffer{}
+			}
+			labels := point.Tags
+			if sample.TestID != "" {
+				labels = append([]string{"test_id", sample.TestID},
+					labels...)
+			}
+			writeGauge(lines[point.Name], name, point.Value, labels...)
+		}
+	}
+	for _, gauge := range PROMETHEUS_GAUGES {
+		if lines[gauge.Point] == nil {
+			continue
+		}
+		writeGaugeHeader(buffer, gauge.Name, gauge.Help)
+		buffer.Write(lines[gauge.Point].Bytes())
+	}
+	return buffer.Bytes()
+}
+
+// Returns copy of the sample with the processes of the highest CPU usage.
+//
+// params: sample *Sample   Collected sample.
+//         top    int       Maximal count of processes.
+func topProcesses(sample *Sample, top int) *Sample {
+	if len(sample.Processes) <= top {
+		return sample
+	}
+	result := *sample
+	result.Processes = make([]*ProcessSample, len(sample.Processes))
+	copy(result.Processes, sample.Processes)
+	sort.Sort(ProcessSamplesByCPU(result.Processes))
+	result.Processes = result.Processes[:top]
+	return &result
+}
+
+// Writes HELP and TYPE lines of the gauge.
+func writeGaugeHeader(buffer *bytes.Buffer, name string, help string) {
+	fmt.Fprintf(buffer, "# HELP %s%s %s\n", METRICS_PREFIX, name, help)
+	fmt.Fprintf(buffer, "# TYPE %s%s gauge\n", METRICS_PREFIX, name)
+}
+
+// Writes gauge value line.
+//
+// params: buffer *bytes.Buffer   Output buffer.
+//         name   string          Metric name without prefix.
+//         value  float64         Gauge value.
+//         labels ...string       Label names and values.
+func writeGauge(buffer *bytes.Buffer, name string, value float64,
+	labels ...string) {
+	buffer.WriteString(METRICS_PREFIX + name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs,
+				labels[i]+"=\""+escapeLabel(labels[i+1])+"\"")
+		}
+		buffer.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buffer.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+// Escapes label value of text exposition format.
+func escapeLabel(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	return strings.Replace(value, "\n", "\\n", -1)
+}
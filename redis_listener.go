@@ -1,13 +1,28 @@
 package container_monitor
 
 import (
+	"errors"
 	"gopkg.in/redis.v4"
 	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	PING_INTERVAL       = time.Second * 5        // Ping interval of idle connection.
+	RECONNECT_MIN_DELAY = time.Millisecond * 500 // First reconnect delay.
+	RECONNECT_MAX_DELAY = time.Second * 30       // Maximal reconnect delay.
 )
 
 type RedisListener struct {
-	Client  *redis.Client     // Redis client
-	monitor *ContainerMonitor // Container monitor.
+	Client        *redis.Client // Redis client
+	controller    *Controller   // Control commands handler.
+	consumer      string        // Stream consumer name, empty for pub/sub.
+	reconnects    int64         // Count of reconnects to Redis.
+	close_channel chan bool     // Channel for close signal.
+	close_once    sync.Once     // Guards closing of the listener.
 }
 
 // Returns new instance of Redis listener.
@@ -15,37 +30,135 @@ type RedisListener struct {
 //        password string   Redis server password.
 //        db       int      Redis server Data Base ID.
 func NewRedisListener(r_url string, password string, db int) *RedisListener {
+	client := redis.NewClient(&redis.Options{
+		Addr:     r_url,
+		Password: password,
+		DB:       db,
+	})
 	return &RedisListener{
-		Client: redis.NewClient(&redis.Options{
-			Addr:     r_url,
-			Password: password,
-			DB:       db,
-		}),
+		Client:        client,
+		controller:    NewController(NewSystemInfoFactory(client)),
+		close_channel: make(chan bool),
 	}
 }
 
-// Listens Redis pub/sub channel.
+// Listens Redis pub/sub channel or stream until the listener is closed.
+// Reconnects with exponential backoff and subscribes again when the
+// connection to Redis is lost.
 func (l *RedisListener) Listen() {
-	defer l.Client.Close()
+	for {
+		var err error
+		if l.usesStream() {
+			err = l.listenStream()
+		} else {
+			err = l.listen()
+		}
+		if l.closed() {
+			return
+		}
+		log.Printf("redis connection lost: %s", err.Error())
+		if !l.reconnect() {
+			return
+		}
+	}
+}
+
+// Spools samples which can not be written to Redis to files in the
+// directory, so they are replayed after Redis outages and restarts.
+//
+// param: dir string   Spool directory.
+func (l *RedisListener) SetSpoolDir(dir string) error {
+	return l.controller.SetSpoolDir(dir)
+}
+
+// Returns control commands handler of the listener, so other transports can
+// drive the same tests.
+func (l *RedisListener) Controller() *Controller {
+	return l.controller
+}
+
+// Returns count of reconnects to Redis since the listener was created.
+func (l *RedisListener) Reconnects() int64 {
+	return atomic.LoadInt64(&l.reconnects)
+}
+
+// Subscribes to Redis pub/sub channel and reads messages until the
+// connection fails. An idle subscription is pinged over its own connection,
+// and the connection is lost if the pong does not arrive in PING_INTERVAL,
+// so a half-open connection is not kept after a failover.
+//
+// return: Connection error.
+func (l *RedisListener) listen() error {
 	pubsub, err := l.Client.Subscribe(STRESS_TEST_CHANNEL)
 	if err != nil {
-		log.Printf("CLIENT SUBSCRIBE ERROR: %s", err.Error())
-		return
+		return err
 	}
-	defer pubsub.Unsubscribe(STRESS_TEST_CHANNEL)
+	defer pubsub.Close()
+	pinged := false
 	for {
-		err := l.ping()
+		received, err := pubsub.ReceiveTimeout(PING_INTERVAL)
+		if l.closed() {
+			return nil
+		}
+		if net_err, ok := err.(net.Error); ok && net_err.Timeout() {
+			if pinged {
+				return errors.New("no pong from redis subscription")
+			}
+			pinged = true
+			err = pubsub.Ping("")
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
-			log.Printf("CLIENT PING ERROR: %s", err.Error())
-			return
+			return err
 		}
-		mess, err := pubsub.ReceiveMessage()
-		if err == nil {
+		pinged = false
+		if mess, ok := received.(*redis.Message); ok {
 			l.readRedisMessage(mess)
 		}
 	}
 }
 
+// Pings Redis with exponential backoff until it is reachable again.
+// Samples kept by the running tests are flushed after the reconnect.
+//
+// return: False if the listener was closed while reconnecting.
+func (l *RedisListener) reconnect() bool {
+	delay := RECONNECT_MIN_DELAY
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-l.close_channel:
+			return false
+		}
+		err := l.ping()
+		if err == nil {
+			count := atomic.AddInt64(&l.reconnects, 1)
+			log.Printf("redis reconnected after %d attempts, reconnects: %d",
+				attempt, count)
+			l.controller.Flush()
+			return true
+		}
+		log.Printf("can not reconnect to redis: %s", err.Error())
+		delay *= 2
+		if delay > RECONNECT_MAX_DELAY {
+			delay = RECONNECT_MAX_DELAY
+		}
+	}
+}
+
+// Returns true if the listener is closed.
+func (l *RedisListener) closed() bool {
+	select {
+	case <-l.close_channel:
+		return true
+	default:
+		return false
+	}
+}
+
 // Calls redis pub/sub channel.
 func (l *RedisListener) Call(test_id string, command string) {
 	mess := newRedisMessage(command, test_id)
@@ -54,46 +167,147 @@ func (l *RedisListener) Call(test_id string, command string) {
 		log.Printf("can not create start redis message %s", err.Error())
 		return
 	}
-	l.Client.Publish(STRESS_TEST_CHANNEL, message_string)
+	_, err = l.send(message_string)
+	if err != nil {
+		log.Printf("can not send redis message %s", err.Error())
+	}
+}
+
+// Sends the command message to the stream or publishes it to pub/sub
+// channel.
+//
+// param: message_string string   JSON command message.
+// return: Count of receivers, always 1 for the stream because the entry
+//         stays in the stream for monitors which are not running yet.
+func (l *RedisListener) send(message_string string) (int64, error) {
+	if l.usesStream() {
+		return 1, l.addToStream(message_string)
+	}
+	return l.Client.Publish(STRESS_TEST_CHANNEL, message_string).Result()
+}
+
+// Sends the command and waits for the reply of the monitor.
+// Fails fast with ErrNoMonitor if no monitor listens the channel. A command
+// sent to the stream waits for a monitor until the timeout.
+//
+// params: test_id string          Stress test ID.
+//         command string          Kind of command.
+//         timeout time.Duration   Maximal time to wait for the reply.
+// return: Reply of the monitor, ErrNoMonitor, ErrReplyTimeout or Redis error.
+func (l *RedisListener) CallAndWait(test_id string, command string,
+	timeout time.Duration) (*RedisReply, error) {
+	return l.CallWithOptions(test_id, command, nil, timeout)
+}
+
+// Sends the command with test options and waits for the reply of the
+// monitor, for example to start a test with its own sampling interval.
+//
+// params: test_id string          Stress test ID.
+//         command string          Kind of command.
+//         options *TestOptions    Test options, nil for defaults.
+//         timeout time.Duration   Maximal time to wait for the reply.
+// return: Reply of the monitor, ErrNoMonitor, ErrReplyTimeout or Redis error.
+func (l *RedisListener) CallWithOptions(test_id string, command string,
+	options *TestOptions, timeout time.Duration) (*RedisReply, error) {
+	mess := newRedisMessage(command, test_id)
+	mess.Options = options
+	mess.ReplyTo = STRESS_TEST_REPLY_CHANNEL + ":" + mess.RequestID
+	message_string, err := marshalRedisMessage(mess)
+	if err != nil {
+		return nil, err
+	}
+	pubsub, err := l.Client.Subscribe(mess.ReplyTo)
+	if err != nil {
+		return nil, err
+	}
+	defer pubsub.Close()
+
+	deadline := time.Now().Add(timeout)
+	// The reply channel must be subscribed before the command is published.
+	_, err = pubsub.ReceiveTimeout(timeout)
+	if err != nil {
+		return nil, replyError(err)
+	}
+	receivers, err := l.send(message_string)
+	if err != nil {
+		return nil, err
+	}
+	// The stream keeps the command for monitors which start later, so
+	// ErrNoMonitor is returned for pub/sub only and the stream waits for the
+	// reply until the timeout.
+	if receivers == 0 {
+		return nil, ErrNoMonitor
+	}
+	for {
+		left := deadline.Sub(time.Now())
+		if left <= 0 {
+			return nil, ErrReplyTimeout
+		}
+		received, err := pubsub.ReceiveTimeout(left)
+		if err != nil {
+			return nil, replyError(err)
+		}
+		message, ok := received.(*redis.Message)
+		if !ok {
+			continue
+		}
+		reply, err := unmarshalRedisReply(message.Payload)
+		if err != nil || reply.RequestID != mess.RequestID {
+			continue
+		}
+		return reply, nil
+	}
+}
+
+// Returns ErrReplyTimeout for network timeout errors.
+func replyError(err error) error {
+	if net_err, ok := err.(net.Error); ok && net_err.Timeout() {
+		return ErrReplyTimeout
+	}
+	return err
 }
 
 // Closes listener
 func (l *RedisListener) Close() {
-	l.Client.Close()
+	l.close_once.Do(func() {
+		close(l.close_channel)
+		err := l.controller.Close()
+		if err != nil {
+			log.Printf("can not close sinks: %s", err.Error())
+		}
+		l.Client.Close()
+	})
 }
 
-// Reads Redis pub/sub messages.
+// Reads Redis pub/sub messages and publishes the reply.
 func (l *RedisListener) readRedisMessage(mess *redis.Message) {
 	message, err := unmarshalRedisMessage(mess.Payload)
 	if err != nil {
 		log.Printf("Can not unmarshall redis message: %s", err.Error())
+		l.reply(STRESS_TEST_REPLY_CHANNEL,
+			newRedisReply(&redisMessage{}, STATUS_ERROR, err))
 		return
 	}
-	if message.Command == START_COMMAND {
-		l.startTest(message.TestID)
-	} else {
-		l.stopTest(message.TestID)
-	}
+	l.reply(message.replyChannel(), l.controller.Handle(message))
 }
 
-// Starts gathering information about the container system.
-func (l *RedisListener) startTest(test_id string) {
-	if l.monitor != nil {
-		log.Println("ERROR: last test not finiched!")
-		return
+// Publishes reply to Redis pub/sub reply channel.
+//
+// params: channel string        Reply channel.
+//         reply   *RedisReply   Reply.
+func (l *RedisListener) reply(channel string, reply *RedisReply) {
+	if reply.Error != "" {
+		log.Printf("command %q of test %q failed: %s",
+			reply.Command, reply.TestID, reply.Error)
 	}
-	l.monitor = newContainerMonitor(l.Client, test_id)
-	go l.monitor.Run()
-}
-
-// Stops gathering information about the container system.
-func (l *RedisListener) stopTest(test_id string) {
-	if l.monitor == nil {
-		log.Println("ERROR: test not started!")
+	reply_string, err := marshalRedisReply(reply)
+	if err != nil {
 		return
 	}
-	l.monitor.Stop()
-	l.monitor = nil
+	err = l.Client.Publish(channel, reply_string).Err()
+	if err != nil {
+		log.Printf("can not publish redis reply %s", err.Error())
+	}
 }
 
 // Pings redis pub/sub channel.
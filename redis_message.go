@@ -1,20 +1,63 @@
 package container_monitor
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
+	"time"
 )
 
 const (
-	START_COMMAND       = "start_test"         // Stress test started.
-	STOP_COMMAND        = "stop_test"          // Stress test stopped.
-	STRESS_TEST_CHANNEL = "stress_test_client" // Redis channel name.
+	START_COMMAND             = "start_test"          // Stress test started.
+	STOP_COMMAND              = "stop_test"           // Stress test stopped.
+	STATUS_COMMAND            = "status"              // Status of running tests.
+	PAUSE_COMMAND             = "pause"               // Pause writing of samples.
+	RESUME_COMMAND            = "resume"              // Resume writing of samples.
+	SNAPSHOT_COMMAND          = "snapshot"            // One instant sample.
+	ABORT_COMMAND             = "abort"               // Stop and discard the data.
+	LIST_COMMAND              = "list"                // Tests with stored data.
+	KEEPALIVE_COMMAND         = "keepalive"           // Stress client is alive.
+	REPORT_COMMAND            = "report"              // System info of the test.
+	STRESS_TEST_CHANNEL       = "stress_test_client"  // Redis channel name.
+	STRESS_TEST_REPLY_CHANNEL = "stress_test_monitor" // Redis channel for replies.
+)
+
+const (
+	STATUS_STARTED         = "started"         // Test started.
+	STATUS_STOPPED         = "stopped"         // Test stopped.
+	STATUS_PAUSED          = "paused"          // Test paused.
+	STATUS_RESUMED         = "resumed"         // Test resumed.
+	STATUS_ABORTED         = "aborted"         // Test stopped and data deleted.
+	STATUS_OK              = "ok"              // Query command done.
+	STATUS_ALREADY_RUNNING = "already_running" // Test with the ID is running.
+	STATUS_NOT_FOUND       = "not_found"       // Test with the ID is not running.
+	STATUS_ERROR           = "error"           // Command failed.
 )
 
 // Redis pub/sub message
 type redisMessage struct {
-	Command string
-	TestID  string
+	Command   string
+	TestID    string
+	RequestID string       // Unique ID of the message, repeated in the reply.
+	ReplyTo   string       // Reply channel, STRESS_TEST_REPLY_CHANNEL if empty.
+	Options   *TestOptions // Options of start command, nil for defaults.
+}
+
+// Reply of the monitor to Redis pub/sub message.
+type RedisReply struct {
+	Command   string        // Command of the message.
+	TestID    string        // Stress test ID of the message.
+	RequestID string        // Request ID of the message.
+	Status    string        // Outcome of the command.
+	Error     string        // Error message if the command failed.
+	Tests     []*TestStatus // Running tests for status command.
+	TestIDs   []string      // Tests with stored data for list command.
+	Snapshot  *Sample       // Instant sample for snapshot command.
+	Report    *SystemInfo   // System info for report command.
+	err       error         // Error of the command, not encoded.
 }
 
 // Returns new instance of Redis pub/sub message.
@@ -23,9 +66,29 @@ type redisMessage struct {
 //         test_id string   Stress test ID.
 func newRedisMessage(command string, test_id string) *redisMessage {
 	return &redisMessage{
-		Command: command,
-		TestID:  test_id,
+		Command:   command,
+		TestID:    test_id,
+		RequestID: newRequestID(),
+	}
+}
+
+// Returns random request ID.
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Printf("can not generate request ID %s", err.Error())
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
 	}
+	return hex.EncodeToString(id)
+}
+
+// Returns reply channel of the message.
+func (m *redisMessage) replyChannel() string {
+	if m.ReplyTo == "" {
+		return STRESS_TEST_REPLY_CHANNEL
+	}
+	return m.ReplyTo
 }
 
 // Encodes Redis pub/sub message instance to JSON string.
@@ -41,6 +104,53 @@ func marshalRedisMessage(message *redisMessage) (string, error) {
 	return string(message_string), nil
 }
 
+// Returns reply to the message.
+//
+// params: message *redisMessage   Instance of Redis pub/sub message.
+//         status  string          Outcome of the command.
+//         err     error           Command error or nil.
+func newRedisReply(
+	message *redisMessage, status string, err error) *RedisReply {
+	reply := &RedisReply{
+		Command:   message.Command,
+		TestID:    message.TestID,
+		RequestID: message.RequestID,
+		Status:    status,
+	}
+	if err != nil {
+		reply.err = err
+		reply.Error = err.Error()
+		switch err {
+		case ErrTestRunning:
+			reply.Status = STATUS_ALREADY_RUNNING
+		case ErrTestNotRunning, ErrTestNotFound:
+			reply.Status = STATUS_NOT_FOUND
+		default:
+			reply.Status = STATUS_ERROR
+		}
+	}
+	return reply
+}
+
+// Checks that the message has a known command and a test ID if the command
+// needs it.
+//
+// param: message *redisMessage   Instance of Redis pub/sub message.
+func validateRedisMessage(message *redisMessage) error {
+	switch message.Command {
+	case START_COMMAND, STOP_COMMAND, PAUSE_COMMAND, RESUME_COMMAND,
+		ABORT_COMMAND, KEEPALIVE_COMMAND, REPORT_COMMAND:
+		if message.TestID == "" {
+			return &InvalidRequestError{Err: ErrEmptyTestID}
+		}
+	case STATUS_COMMAND, SNAPSHOT_COMMAND, LIST_COMMAND:
+	default:
+		return &InvalidRequestError{Err: fmt.Errorf(
+			"%w: %q", ErrUnknownCommand, message.Command)}
+	}
+	return nil
+}
+
 // Decodes Redis pub/sub message JSON string to instance of *redisMessage
 //
 // param: message_string string   JSON message string.
@@ -54,3 +164,30 @@ func unmarshalRedisMessage(message_string string) (*redisMessage, error) {
 	}
 	return mess, nil
 }
+
+// Encodes reply to JSON string.
+//
+// param: reply *RedisReply   Instance of reply.
+// return JSON string or error instance.
+func marshalRedisReply(reply *RedisReply) (string, error) {
+	reply_string, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Can not marshal redis reply %s", err.Error())
+		return err.Error(), err
+	}
+	return string(reply_string), nil
+}
+
+// Decodes reply JSON string to instance of *RedisReply.
+//
+// param: reply_string string   JSON reply string.
+// return: Instance of *RedisReply or error instance.
+func unmarshalRedisReply(reply_string string) (*RedisReply, error) {
+	reply := &RedisReply{}
+	err := json.Unmarshal([]byte(reply_string), reply)
+	if err != nil {
+		log.Printf("Can not unmarshal redis reply %s", err.Error())
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,273 @@
+package container_monitor
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const REDIS_RETRY_DELAY = time.Second * 5 // Delay of write after a failure.
+
+// Stores samples of tests in Redis. Samples which can not be written are
+// queued for every test, in memory or in spool files, and written in order
+// when Redis is back. After a failed write, samples are only queued for
+// REDIS_RETRY_DELAY, so the sink keeps up with sampling during an outage.
+type RedisSink struct {
+	info_factory *SystemInfoFactory     // System info factory.
+	queues       map[string]sampleQueue // Queues of running tests.
+	spool_dir    string                 // Spool directory, empty for memory queue.
+	retry_at     time.Time              // Samples are queued until the time.
+	writer_id    string                 // Writer ID of samples of the sink.
+	sequence     int64                  // Sequence number of the last sample.
+	lock         sync.Mutex             // Queues lock.
+}
+
+// Returns new Redis sink.
+//
+// param: factory *SystemInfoFactory   System info factory.
+func NewRedisSink(factory *SystemInfoFactory) *RedisSink {
+	return &RedisSink{
+		info_factory: factory,
+		queues:       make(map[string]sampleQueue),
+		writer_id:    newRequestID(),
+	}
+}
+
+// Spools samples which can not be written to Redis to files in the
+// directory. Samples are kept in memory if the directory is not set.
+//
+// param: dir string   Spool directory.
+func (s *RedisSink) SetSpoolDir(dir string) error {
+	err := os.MkdirAll(dir, 0750)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.spool_dir = dir
+	return nil
+}
+
+// Marks the test as started and writes samples left by the previous run of
+// the test, unless a write has failed in the last REDIS_RETRY_DELAY.
+//
+// param: test_id string   Test ID.
+func (s *RedisSink) StartTest(test_id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	queue := s.queue(test_id)
+	if !time.Now().Before(s.retry_at) {
+		s.flush(test_id, queue)
+	}
+	return s.info_factory.StartTest(test_id)
+}
+
+// Writes the sample of the test. The sample is queued if Redis is
+// unreachable or older samples are still queued. A copy of the sample is
+// numbered by the sink, so a replayed sample is recognized by Redis.
+//
+// param: sample *Sample   Sample of the test.
+func (s *RedisSink) Write(sample *Sample) error {
+	if sample.TestID == "" {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	numbered := *sample
+	s.sequence++
+	numbered.WriterID = s.writer_id
+	numbered.Sequence = s.sequence
+	sample = &numbered
+	queue := s.queue(sample.TestID)
+	waiting := time.Now().Before(s.retry_at)
+	if queue.Len() == 0 && !waiting {
+		err := s.info_factory.WriteSample(sample.TestID, sample)
+		if err == nil {
+			return nil
+		}
+		log.Printf("can not write sample: %s", err.Error())
+		s.retry_at = time.Now().Add(REDIS_RETRY_DELAY)
+		waiting = true
+	}
+	err := queue.Push(sample)
+	if err != nil {
+		return err
+	}
+	if !waiting {
+		s.flush(sample.TestID, queue)
+	}
+	return nil
+}
+
+// Writes queued samples of the test, unless a write has failed in the last
+// REDIS_RETRY_DELAY, records the stop reason and closes the queue.
+// Unwritten samples stay in the spool until Redis is back.
+//
+// params: test_id string   Test ID.
+//         reason  string   Stop reason.
+func (s *RedisSink) EndTest(test_id string, reason string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	queue, ok := s.queues[test_id]
+	if ok {
+		if !time.Now().Before(s.retry_at) {
+			s.flush(test_id, queue)
+		}
+		if queue.Len() > 0 {
+			log.Printf("test %s has %d unwritten samples",
+				test_id, queue.Len())
+		}
+		delete(s.queues, test_id)
+		empty := queue.Len() == 0
+		err := queue.Close()
+		if err != nil {
+			log.Printf("can not close queue of test %s: %s",
+				test_id, err.Error())
+		}
+		if empty && s.spool_dir != "" {
+			err = removeSpool(spoolPath(s.spool_dir, test_id))
+			if err != nil {
+				log.Printf("can not remove spool of test %s: %s",
+					test_id, err.Error())
+			}
+		}
+	}
+	return s.info_factory.StopTest(test_id, reason)
+}
+
+// Writes samples queued while Redis was unreachable, of running tests and
+// of tests left in the spool directory.
+func (s *RedisSink) Flush() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.retry_at = time.Time{}
+	for test_id, queue := range s.queues {
+		s.flush(test_id, queue)
+	}
+	s.drainSpools()
+}
+
+// Removes spool files of the test.
+//
+// param: test_id string   Test ID.
+func (s *RedisSink) RemoveSpool(test_id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.spool_dir == "" {
+		return nil
+	}
+	return removeSpool(spoolPath(s.spool_dir, test_id))
+}
+
+// Closes queues of all tests.
+func (s *RedisSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for test_id, queue := range s.queues {
+		queue.Close()
+		delete(s.queues, test_id)
+	}
+	return nil
+}
+
+// Returns queue of the test, opens it for the first sample. Falls back to
+// memory queue if the spool file can not be opened.
+func (s *RedisSink) queue(test_id string) sampleQueue {
+	queue, ok := s.queues[test_id]
+	if ok {
+		return queue
+	}
+	queue = newMemoryQueue(MAX_PENDING_SAMPLES)
+	if s.spool_dir != "" {
+		spool, err := newDiskSpool(
+			spoolPath(s.spool_dir, test_id), MAX_SPOOL_SIZE)
+		if err == nil {
+			queue = spool
+		} else {
+			log.Printf("can not open spool of test %s: %s",
+				test_id, err.Error())
+		}
+	}
+	s.queues[test_id] = queue
+	return queue
+}
+
+// Writes samples of the spool files of tests which are not running, for
+// example of tests which ended while Redis was unreachable or before a
+// restart. Spool files are removed when all their samples are written.
+func (s *RedisSink) drainSpools() {
+	if s.spool_dir == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(s.spool_dir)
+	if err != nil {
+		log.Printf("can not read spool directory: %s", err.Error())
+		return
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), SPOOL_FILE_EXT) {
+			continue
+		}
+		path := filepath.Join(s.spool_dir, file.Name())
+		test_id, err := spoolTestID(path)
+		if err != nil {
+			log.Printf("can not find test of spool %s: %s", path, err.Error())
+			continue
+		}
+		if _, ok := s.queues[test_id]; ok {
+			continue
+		}
+		spool, err := newDiskSpool(path, MAX_SPOOL_SIZE)
+		if err != nil {
+			log.Printf("can not open spool %s: %s", path, err.Error())
+			continue
+		}
+		err = s.flush(test_id, spool)
+		empty := spool.Len() == 0
+		spool.Close()
+		if err != nil {
+			return
+		}
+		if empty {
+			err = removeSpool(path)
+			if err != nil {
+				log.Printf("can not remove spool %s: %s", path, err.Error())
+			}
+		}
+	}
+}
+
+// Writes queued samples of the test in order until Redis fails.
+//
+// params: test_id string        Test ID.
+//         queue   sampleQueue   Queue of the test.
+// return: Error which stopped the writing.
+func (s *RedisSink) flush(test_id string, queue sampleQueue) error {
+	for queue.Len() > 0 {
+		sample, err := queue.Peek()
+		if err != nil {
+			log.Printf("can not read queued sample: %s", err.Error())
+			return err
+		}
+		if sample == nil {
+			return nil
+		}
+		err = s.info_factory.WriteSample(test_id, sample)
+		if err != nil {
+			log.Printf("can not write sample, %d queued: %s",
+				queue.Len(), err.Error())
+			s.retry_at = time.Now().Add(REDIS_RETRY_DELAY)
+			return err
+		}
+		err = queue.Pop()
+		if err != nil {
+			log.Printf("can not remove queued sample: %s", err.Error())
+			return err
+		}
+	}
+	return nil
+}
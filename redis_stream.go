@@ -0,0 +1,216 @@
+package container_monitor
+
+import (
+	"errors"
+	"gopkg.in/redis.v4"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	STRESS_TEST_STREAM  = "stress_test_commands" // Redis stream of commands.
+	STRESS_TEST_GROUP   = "stress_test_monitor"  // Prefix of monitor groups.
+	STREAM_FIELD        = "message"              // Stream entry field of command.
+	STREAM_MAX_LENGTH   = 10000                  // Approximate stream length cap.
+	STREAM_READ_COUNT   = 10                     // Entries read at once.
+	STREAM_BLOCK_MILLIS = 1000                   // Blocking read timeout.
+	STREAM_MAX_AGE      = time.Minute * 10       // Older commands are skipped.
+)
+
+// Entry of the command stream.
+type streamEntry struct {
+	ID      string // Stream entry ID.
+	Payload string // JSON command message.
+}
+
+// Reads commands from the stream through the consumer group of the monitor
+// until the connection fails. Every monitor has its own group, so every
+// monitor reads every command. Commands delivered to the consumer before a
+// restart but not acknowledged are handled first. Every command is
+// acknowledged with XACK after it was handled.
+//
+// return: Connection error.
+func (l *RedisListener) listenStream() error {
+	err := l.createGroup()
+	if err != nil {
+		return err
+	}
+	// "0" reads pending entries of the consumer, ">" reads new entries.
+	last_id := "0"
+	for {
+		entries, err := l.readStream(last_id)
+		if l.closed() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if last_id == "0" && len(entries) == 0 {
+			last_id = ">"
+			continue
+		}
+		for _, entry := range entries {
+			l.readStreamEntry(entry)
+		}
+	}
+}
+
+// Creates the consumer group of the monitor if it does not exist.
+// A new group reads only commands added after it was created, so commands
+// sent to other monitors, for example to tests of a replaced container,
+// are never replayed. Redis keeps the last read ID of the group across
+// restarts of the monitor.
+func (l *RedisListener) createGroup() error {
+	cmd := redis.NewCmd("XGROUP", "CREATE", STRESS_TEST_STREAM,
+		l.group(), "$", "MKSTREAM")
+	l.Client.Process(cmd)
+	err := cmd.Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// Reads entries of the stream for the consumer.
+//
+// param: last_id string   "0" for pending entries, ">" for new entries.
+// return: Stream entries, empty if the read timed out.
+func (l *RedisListener) readStream(last_id string) ([]*streamEntry, error) {
+	args := []interface{}{"XREADGROUP", "GROUP", l.group(),
+		l.consumer, "COUNT", STREAM_READ_COUNT}
+	if last_id == ">" {
+		args = append(args, "BLOCK", STREAM_BLOCK_MILLIS)
+	}
+	args = append(args, "STREAMS", STRESS_TEST_STREAM, last_id)
+	cmd := redis.NewCmd(args...)
+	l.Client.Process(cmd)
+	result, err := cmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseStreamEntries(result)
+}
+
+// Handles the command of the stream entry and acknowledges the entry.
+// Entries which can not be decoded are acknowledged too, so they are not
+// delivered again. Commands older than STREAM_MAX_AGE are acknowledged
+// without handling, so commands sent during a long downtime of the monitor
+// are not executed late.
+//
+// param: entry *streamEntry   Stream entry.
+func (l *RedisListener) readStreamEntry(entry *streamEntry) {
+	if time.Since(streamEntryTime(entry.ID)) > STREAM_MAX_AGE {
+		log.Printf("command %s is too old, skipped", entry.ID)
+	} else {
+		l.readRedisMessage(&redis.Message{
+			Channel: STRESS_TEST_STREAM,
+			Payload: entry.Payload,
+		})
+	}
+	cmd := redis.NewCmd("XACK", STRESS_TEST_STREAM, l.group(), entry.ID)
+	l.Client.Process(cmd)
+	if cmd.Err() != nil {
+		log.Printf("can not acknowledge command %s: %s",
+			entry.ID, cmd.Err().Error())
+	}
+}
+
+// Adds the command message to the stream.
+//
+// param: message_string string   JSON command message.
+func (l *RedisListener) addToStream(message_string string) error {
+	cmd := redis.NewCmd("XADD", STRESS_TEST_STREAM, "MAXLEN", "~",
+		STREAM_MAX_LENGTH, "*", STREAM_FIELD, message_string)
+	l.Client.Process(cmd)
+	return cmd.Err()
+}
+
+// Decodes XREADGROUP reply: list of streams, each one is a list of the
+// stream name and its entries, each entry is a list of the ID and fields.
+//
+// param: result interface{}   XREADGROUP reply.
+func parseStreamEntries(result interface{}) ([]*streamEntry, error) {
+	streams, ok := result.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid stream reply")
+	}
+	entries := []*streamEntry{}
+	for _, stream := range streams {
+		stream_reply, ok := stream.([]interface{})
+		if !ok || len(stream_reply) != 2 {
+			return nil, errors.New("invalid stream reply")
+		}
+		items, ok := stream_reply[1].([]interface{})
+		if !ok {
+			return nil, errors.New("invalid stream entries")
+		}
+		for _, item := range items {
+			entry, err := parseStreamEntry(item)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Returns time when the stream entry was added. The entry ID starts with
+// the time in milliseconds. Zero time is returned for invalid IDs.
+//
+// param: id string   Stream entry ID.
+func streamEntryTime(id string) time.Time {
+	ms, err := strconv.ParseInt(strings.SplitN(id, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Decodes stream entry of ID and list of field names and values.
+// Deleted entries of pending list have no fields and an empty payload.
+func parseStreamEntry(item interface{}) (*streamEntry, error) {
+	entry_reply, ok := item.([]interface{})
+	if !ok || len(entry_reply) != 2 {
+		return nil, errors.New("invalid stream entry")
+	}
+	id, ok := entry_reply[0].(string)
+	if !ok {
+		return nil, errors.New("invalid stream entry ID")
+	}
+	entry := &streamEntry{ID: id}
+	fields, _ := entry_reply[1].([]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if field, _ := fields[i].(string); field == STREAM_FIELD {
+			entry.Payload, _ = fields[i+1].(string)
+		}
+	}
+	return entry, nil
+}
+
+// Reads commands from Redis stream through the consumer group of the
+// monitor instead of pub/sub channel. Commands sent while the monitor was
+// down are handled after the restart. Replies are still published to
+// pub/sub channels. Must be called before Listen.
+//
+// param: consumer string   Unique name of the monitor which is kept across
+//                          restarts, for example the service name. It
+//                          names the group of the monitor.
+func (l *RedisListener) UseStream(consumer string) {
+	l.consumer = consumer
+}
+
+// Returns consumer group of the monitor.
+func (l *RedisListener) group() string {
+	return STRESS_TEST_GROUP + ":" + l.consumer
+}
+
+// Returns true if commands are read from Redis stream.
+func (l *RedisListener) usesStream() bool {
+	return l.consumer != ""
+}
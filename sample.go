@@ -0,0 +1,45 @@
+package container_monitor
+
+import (
+	"github.com/shirou/gopsutil/mem"
+	"time"
+)
+
+// Values collected during one sample of the container system information.
+type Sample struct {
+	TestID        string                 // Test of the sample, empty outside tests.
+	Time          time.Time              // Sample time.
+	CPUusage      float64                // CPU usage in percents of CPULimit.
+	CPULimit      float64                // Effective CPU cores of the container.
+	VirtualMemory *mem.VirtualMemoryStat // Virtual memory usage.
+	SWAPmemory    *mem.SwapMemoryStat    // Swap memory usage.
+	Processes     []*ProcessSample       // Processes info.
+	Collectors    []string               // Collectors which filled the sample.
+	WriterID      string                 // Redis sink which queued the sample.
+	Sequence      int64                  // Number of the sample in its writer.
+}
+
+// Sorts samples by test ID.
+type SamplesByTestID []*Sample
+
+// Returns length of sortable array.
+func (b SamplesByTestID) Len() int {
+	return len(b)
+}
+
+// Swaps array indexes.
+func (b SamplesByTestID) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// Returns sort rule.
+func (b SamplesByTestID) Less(i, j int) bool {
+	return b[i].TestID < b[j].TestID
+}
+
+// Returns true if the collector filled the sample.
+//
+// param: collector string   Collector name, for example COLLECTOR_CPU.
+func (s *Sample) Has(collector string) bool {
+	return hasCollector(s.Collectors, collector)
+}
@@ -0,0 +1,69 @@
+package container_monitor
+
+import (
+	"log"
+)
+
+const MAX_PENDING_SAMPLES = 10000 // Maximal count of samples in memory queue.
+
+// Queue of samples which are not written to Redis yet. Samples are read in
+// the order they were pushed.
+type sampleQueue interface {
+	Push(sample *Sample) error // Appends the sample to the queue.
+	Peek() (*Sample, error)    // Returns the oldest sample, nil if empty.
+	Pop() error                // Removes the oldest sample.
+	Len() int                  // Count of samples in the queue.
+	Close() error              // Releases resources of the queue.
+}
+
+// Queue of samples kept in memory. The oldest sample is dropped when the
+// queue is full.
+type memoryQueue struct {
+	samples []*Sample // Queued samples.
+	max     int       // Maximal count of samples.
+}
+
+// Returns new memory queue.
+//
+// param: max int   Maximal count of samples.
+func newMemoryQueue(max int) *memoryQueue {
+	return &memoryQueue{max: max}
+}
+
+// Appends the sample to the queue.
+func (q *memoryQueue) Push(sample *Sample) error {
+	if len(q.samples) >= q.max {
+		log.Printf("too many pending samples, oldest dropped")
+		q.Pop()
+	}
+	q.samples = append(q.samples, sample)
+	return nil
+}
+
+// Returns the oldest sample, nil if the queue is empty.
+func (q *memoryQueue) Peek() (*Sample, error) {
+	if len(q.samples) == 0 {
+		return nil, nil
+	}
+	return q.samples[0], nil
+}
+
+// Removes the oldest sample.
+func (q *memoryQueue) Pop() error {
+	if len(q.samples) > 0 {
+		q.samples[0] = nil
+		q.samples = q.samples[1:]
+	}
+	return nil
+}
+
+// Returns count of samples in the queue.
+func (q *memoryQueue) Len() int {
+	return len(q.samples)
+}
+
+// Drops all samples.
+func (q *memoryQueue) Close() error {
+	q.samples = nil
+	return nil
+}
@@ -0,0 +1,189 @@
+package container_monitor
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const SAMPLE_INTERVAL = time.Second * 2 // Default sampling interval.
+
+// Subscription of a test to samples.
+type sampleSubscriber struct {
+	samples chan *Sample  // Channel of samples.
+	filter  *sampleFilter // Test options.
+	next    time.Time     // Time when the next sample is due.
+}
+
+// Collects samples of the container system information and fans them out to
+// every subscribed test, so the system is sampled once for all running tests.
+// Every subscriber is scheduled by its own next due time, the subscribers due
+// at the same time share one sample. The schedule is recomputed whenever a
+// test subscribes or unsubscribes.
+// The sampler runs only while it has subscribers.
+type Sampler struct {
+	info_factory  *SystemInfoFactory           // System info factory.
+	lock          sync.Mutex                   // Subscribers lock.
+	subscribers   map[string]*sampleSubscriber // Subscribers by test ID.
+	close_channel chan bool                    // Channel for close signal.
+	wake_channel  chan bool                    // Channel for schedule change.
+}
+
+// Returns new sampler instance.
+//
+// param: factory *SystemInfoFactory   System info factory.
+func NewSampler(factory *SystemInfoFactory) *Sampler {
+	return &Sampler{
+		info_factory: factory,
+		subscribers:  make(map[string]*sampleSubscriber),
+	}
+}
+
+// Subscribes the test to samples. Starts sampling for the first subscriber.
+// The first sample of the test is collected at once.
+//
+// params: test_id string          Test ID.
+//         filter  *sampleFilter   Test options.
+// return: Channel of samples.
+func (s *Sampler) Subscribe(
+	test_id string, filter *sampleFilter) <-chan *Sample {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscriber := &sampleSubscriber{
+		samples: make(chan *Sample, 1),
+		filter:  filter,
+		next:    time.Now(),
+	}
+	s.subscribers[test_id] = subscriber
+	if s.close_channel == nil {
+		s.close_channel = make(chan bool)
+		s.wake_channel = make(chan bool, 1)
+		go s.run(s.close_channel, s.wake_channel)
+	} else {
+		s.wake()
+	}
+	return subscriber.samples
+}
+
+// Unsubscribes the test. Stops sampling after the last subscriber.
+//
+// param: test_id string   Test ID.
+func (s *Sampler) Unsubscribe(test_id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, test_id)
+	if s.close_channel == nil {
+		return
+	}
+	if len(s.subscribers) == 0 {
+		close(s.close_channel)
+		s.close_channel = nil
+		s.wake_channel = nil
+		return
+	}
+	s.wake()
+}
+
+// Signals the running loop to recompute the schedule. Must be called with
+// the lock held.
+func (s *Sampler) wake() {
+	select {
+	case s.wake_channel <- true:
+	default:
+	}
+}
+
+// Collects samples until the close channel is closed.
+//
+// params: close_channel chan bool   Channel for close signal.
+//         wake_channel  chan bool   Channel for schedule change.
+func (s *Sampler) run(close_channel chan bool, wake_channel chan bool) {
+	for {
+		due, window, collectors := s.schedule()
+		timer := time.NewTimer(due.Add(-window).Sub(time.Now()))
+		select {
+		case <-timer.C:
+			s.publish(s.info_factory.collectSample(collectors, window))
+		case <-wake_channel:
+			timer.Stop()
+		case <-close_channel:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Returns the time of the next sample, the CPU measurement window and the
+// collectors enabled by the subscribers due at that time.
+func (s *Sampler) schedule() (time.Time, time.Duration, []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	due := time.Time{}
+	for _, subscriber := range s.subscribers {
+		if due.IsZero() || subscriber.next.Before(due) {
+			due = subscriber.next
+		}
+	}
+	if due.IsZero() {
+		return time.Now().Add(SAMPLE_INTERVAL), PROCESS_SAMPLE_WINDOW,
+			ALL_COLLECTORS
+	}
+	// CPU usage is measured during the half of the interval at most.
+	window := PROCESS_SAMPLE_WINDOW
+	collectors := []string{}
+	for _, subscriber := range s.subscribers {
+		if !s.isDue(subscriber, due) {
+			continue
+		}
+		if window > subscriber.filter.interval/2 {
+			window = subscriber.filter.interval / 2
+		}
+		for _, collector := range subscriber.filter.collectors {
+			if !hasCollector(collectors, collector) {
+				collectors = append(collectors, collector)
+			}
+		}
+	}
+	return due, window, collectors
+}
+
+// Sends the sample to every subscriber which is due and whose collectors
+// were all sampled, and schedules its next sample. A subscriber which has not
+// handled the previous sample yet skips this one.
+//
+// param: sample *Sample   Collected sample.
+func (s *Sampler) publish(sample *Sample) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for test_id, subscriber := range s.subscribers {
+		if !s.isDue(subscriber, now) || !s.covers(sample, subscriber) {
+			continue
+		}
+		select {
+		case subscriber.samples <- subscriber.filter.apply(sample):
+		default:
+			log.Printf("test %s is busy, sample skipped", test_id)
+		}
+		subscriber.next = subscriber.next.Add(subscriber.filter.interval)
+		if !subscriber.next.After(now) {
+			subscriber.next = now.Add(subscriber.filter.interval)
+		}
+	}
+}
+
+// Returns true if the subscriber is due at the time. Half of the minimal
+// interval is a tolerance for the tick jitter.
+func (s *Sampler) isDue(subscriber *sampleSubscriber, now time.Time) bool {
+	return subscriber.next.Before(now.Add(MIN_SAMPLE_INTERVAL / 2))
+}
+
+// Returns true if the sample has every collector of the subscriber.
+func (s *Sampler) covers(sample *Sample, subscriber *sampleSubscriber) bool {
+	for _, collector := range subscriber.filter.collectors {
+		if !sample.Has(collector) {
+			return false
+		}
+	}
+	return true
+}
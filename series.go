@@ -0,0 +1,170 @@
+package container_monitor
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/redis.v4"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SERIES_CPU         = "cpu"       // Total CPU usage in percents.
+	SERIES_VM          = "vm"        // Virtual memory usage in percents.
+	SERIES_VM_USED     = "vm_used"   // Used virtual memory bytes.
+	SERIES_SWAP        = "swap"      // Swap memory usage in percents.
+	SERIES_SWAP_USED   = "swap_used" // Used swap memory bytes.
+	SERIES_PROCESS_CPU = "cpu"       // Process CPU usage in percents.
+	SERIES_PROCESS_MEM = "mem"       // Process memory usage in percents.
+)
+
+// Single value of a metric time series.
+type SeriesPoint struct {
+	Time  time.Time // Sample time.
+	Value float64   // Metric value.
+}
+
+// All time series of a test.
+type SystemSeries struct {
+	CPU           []*SeriesPoint            // Total CPU usage.
+	VirtualMemory []*SeriesPoint            // Virtual memory usage in percents.
+	VMUsed        []*SeriesPoint            // Used virtual memory bytes.
+	SWAPmemory    []*SeriesPoint            // Swap memory usage in percents.
+	SWAPUsed      []*SeriesPoint            // Used swap memory bytes.
+	ProcessCPU    map[string][]*SeriesPoint // CPU usage by process key.
+	ProcessMemory map[string][]*SeriesPoint // Memory usage by process key.
+}
+
+// Returns series name of a process metric.
+//
+// params: process_key string   Process key, see ProcessInfo.Key.
+//         metric      string   SERIES_PROCESS_CPU or SERIES_PROCESS_MEM.
+func ProcessSeries(process_key string, metric string) string {
+	return "pids:" + process_key + ":" + metric
+}
+
+// Reads time series of one metric ordered by time.
+//
+// params: test_id string   ID of the test.
+//         metric  string   Series name, for example SERIES_CPU or the
+//                          result of ProcessSeries.
+func (f *SystemInfoFactory) ReadSeries(
+	test_id string, metric string) ([]*SeriesPoint, error) {
+	members, err := f.redis_client.ZRangeWithScores(
+		seriesKey("system:"+test_id, metric), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseSeries(metric, members), nil
+}
+
+// Reads all time series of the test.
+//
+// param: test_id string   ID of the test.
+func (f *SystemInfoFactory) ReadSystemSeries(
+	test_id string) (*SystemSeries, error) {
+	series := &SystemSeries{
+		ProcessCPU:    make(map[string][]*SeriesPoint),
+		ProcessMemory: make(map[string][]*SeriesPoint),
+	}
+	var err error
+	for metric, target := range map[string]*[]*SeriesPoint{
+		SERIES_CPU:       &series.CPU,
+		SERIES_VM:        &series.VirtualMemory,
+		SERIES_VM_USED:   &series.VMUsed,
+		SERIES_SWAP:      &series.SWAPmemory,
+		SERIES_SWAP_USED: &series.SWAPUsed,
+	} {
+		*target, err = f.ReadSeries(test_id, metric)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	processes, err := f.redis_client.HGetAll(
+		"system:" + test_id + ":pids:names").Result()
+	if err != nil {
+		return nil, err
+	}
+	for process_key := range processes {
+		series.ProcessCPU[process_key], err = f.ReadSeries(
+			test_id, ProcessSeries(process_key, SERIES_PROCESS_CPU))
+		if err != nil {
+			return nil, err
+		}
+		series.ProcessMemory[process_key], err = f.ReadSeries(
+			test_id, ProcessSeries(process_key, SERIES_PROCESS_MEM))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return series, nil
+}
+
+// Queues adding of the value to the metric time series.
+//
+// params: tx     *redis.Tx   Redis transaction.
+//         pref   string      Test key prefix.
+//         metric string      Series name.
+//         now    time.Time   Sample time.
+//         value  float64     Metric value.
+func (f *SystemInfoFactory) writeSeriesPoint(
+	tx *redis.Tx, pref string, metric string, now time.Time, value float64) {
+	tx.ZAdd(seriesKey(pref, metric), seriesMember(now, value))
+}
+
+// Returns Redis key of the metric time series.
+func seriesKey(pref string, metric string) string {
+	return pref + ":series:" + metric
+}
+
+// Returns sorted set member of the series point. Score is the sample time in
+// milliseconds, the member keeps time too so equal values stay unique.
+func seriesMember(now time.Time, value float64) redis.Z {
+	ms := now.UnixNano() / int64(time.Millisecond)
+	return redis.Z{
+		Score: float64(ms),
+		Member: fmt.Sprintf(
+			"%d:%s", ms, strconv.FormatFloat(value, 'f', -1, 64)),
+	}
+}
+
+// Decodes series points from sorted set members. Broken points are skipped.
+//
+// params: metric  string      Series name for log messages.
+//         members []redis.Z   Sorted set members.
+func parseSeries(metric string, members []redis.Z) []*SeriesPoint {
+	points := make([]*SeriesPoint, 0, len(members))
+	for _, member := range members {
+		point, err := parseSeriesPoint(member)
+		if err != nil {
+			log.Printf("can not parse %s series point: %s", metric, err.Error())
+			continue
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+// Decodes series point from sorted set member.
+func parseSeriesPoint(member redis.Z) (*SeriesPoint, error) {
+	member_string, ok := member.Member.(string)
+	if !ok {
+		return nil, errors.New("unexpected series member type")
+	}
+	parts := strings.SplitN(member_string, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("can not find series value")
+	}
+	value, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil, err
+	}
+	ms := int64(member.Score)
+	return &SeriesPoint{
+		Time:  time.Unix(0, ms*int64(time.Millisecond)),
+		Value: value,
+	}, nil
+}
@@ -0,0 +1,287 @@
+package container_monitor
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	SINK_BUFFER        = 100              // Count of samples queued for one lossy sink.
+	SINK_EVENT_TIMEOUT = time.Second * 10 // Maximal wait for test events.
+)
+
+// Receiver of collected samples, for example Redis store or metrics exporter.
+type Sink interface {
+	Write(sample *Sample) error // Writes the sample of the test Sample.TestID.
+	Close() error               // Releases resources of the sink.
+}
+
+// Sink which is notified about start and end of tests.
+type TestSink interface {
+	Sink
+	StartTest(test_id string) error              // Test started.
+	EndTest(test_id string, reason string) error // Test ended with the reason.
+}
+
+// Event of sink worker: a sample or start or end of a test.
+type sinkEvent struct {
+	sample  *Sample    // Sample to write, nil for test events.
+	test_id string     // Test ID of test event.
+	start   bool       // True for start, false for end of the test.
+	reason  string     // Stop reason of end event.
+	done    chan error // Result of test event.
+}
+
+// Writes events to one sink in its own goroutine. Events are queued in
+// order and queueing never blocks: samples over SINK_BUFFER are dropped
+// unless the sink is lossless, test events are always queued.
+type sinkWorker struct {
+	sink     Sink         // Destination sink.
+	name     string       // Sink name for logs.
+	lossless bool         // Samples are never dropped.
+	events   []*sinkEvent // Queued events.
+	samples  int          // Count of queued samples.
+	dropped  int64        // Count of dropped samples.
+	closed   bool         // No events are queued after close.
+	lock     sync.Mutex   // Queue lock.
+	signal   chan bool    // Signal of a queued event.
+}
+
+// Sends every sample to any number of sinks. Every sink has its own queue
+// and goroutine, so a slow or failing sink does not stall collection and
+// other sinks. Samples are dropped for a sink whose queue is full, unless
+// the sink was added as lossless. Errors of a sink are logged with the sink
+// name. Start and end of tests are never dropped, EndTest waits until every
+// sink has written the samples of the test, at most SINK_EVENT_TIMEOUT.
+type FanoutSink struct {
+	workers []*sinkWorker  // Sink workers.
+	lock    sync.Mutex     // Workers lock.
+	group   sync.WaitGroup // Running workers.
+}
+
+// Returns new fan-out of the sinks.
+//
+// param: sinks ...Sink   Destination sinks.
+func NewFanoutSink(sinks ...Sink) *FanoutSink {
+	f := &FanoutSink{}
+	for _, sink := range sinks {
+		f.Add(sink)
+	}
+	return f
+}
+
+// Adds the sink. The sink receives samples written after the call.
+//
+// param: sink Sink   Destination sink.
+func (f *FanoutSink) Add(sink Sink) {
+	f.add(sink, false)
+}
+
+// Adds the sink which receives every sample. Its queue is not bounded, so
+// the sink must keep up with sampling, for example by queueing samples
+// itself while its backend is unreachable.
+//
+// param: sink Sink   Destination sink.
+func (f *FanoutSink) AddLossless(sink Sink) {
+	f.add(sink, true)
+}
+
+// Starts the worker of the sink.
+//
+// params: sink     Sink   Destination sink.
+//         lossless bool   True if samples are never dropped.
+func (f *FanoutSink) add(sink Sink, lossless bool) {
+	worker := &sinkWorker{
+		sink:     sink,
+		name:     fmt.Sprintf("%T", sink),
+		lossless: lossless,
+		signal:   make(chan bool, 1),
+	}
+	f.lock.Lock()
+	f.workers = append(f.workers, worker)
+	f.lock.Unlock()
+	f.group.Add(1)
+	go func() {
+		defer f.group.Done()
+		worker.run()
+	}()
+}
+
+// Queues the sample for every sink. Never blocks.
+//
+// param: sample *Sample   Collected sample.
+func (f *FanoutSink) Write(sample *Sample) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, worker := range f.workers {
+		worker.push(&sinkEvent{sample: sample})
+	}
+	return nil
+}
+
+// Notifies test sinks about start of the test.
+//
+// param: test_id string   Test ID.
+func (f *FanoutSink) StartTest(test_id string) error {
+	return f.notify(&sinkEvent{test_id: test_id, start: true})
+}
+
+// Notifies test sinks about end of the test and waits until the sinks have
+// handled all samples of the test. Returns ErrSinkTimeout if a sink has not
+// handled the end in SINK_EVENT_TIMEOUT, the sink still handles it later.
+//
+// params: test_id string   Test ID.
+//         reason  string   Stop reason.
+func (f *FanoutSink) EndTest(test_id string, reason string) error {
+	return f.notify(&sinkEvent{test_id: test_id, reason: reason})
+}
+
+// Queues test event for every sink and waits for the results without
+// holding the workers lock, at most SINK_EVENT_TIMEOUT. Returns the first
+// error.
+func (f *FanoutSink) notify(event *sinkEvent) error {
+	f.lock.Lock()
+	results := []chan error{}
+	for _, worker := range f.workers {
+		if _, ok := worker.sink.(TestSink); !ok {
+			continue
+		}
+		worker_event := *event
+		worker_event.done = make(chan error, 1)
+		if worker.push(&worker_event) {
+			results = append(results, worker_event.done)
+		}
+	}
+	f.lock.Unlock()
+	timeout := time.NewTimer(SINK_EVENT_TIMEOUT)
+	defer timeout.Stop()
+	var result error
+	for _, done := range results {
+		var err error
+		select {
+		case err = <-done:
+		case <-timeout.C:
+			return ErrSinkTimeout
+		}
+		if err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// Closes all sinks after they have written queued samples.
+func (f *FanoutSink) Close() error {
+	f.lock.Lock()
+	workers := f.workers
+	f.workers = nil
+	f.lock.Unlock()
+	for _, worker := range workers {
+		worker.close()
+	}
+	f.group.Wait()
+	var result error
+	for _, worker := range workers {
+		err := worker.sink.Close()
+		if err != nil {
+			log.Printf("can not close sink %s: %s", worker.name, err.Error())
+			if result == nil {
+				result = err
+			}
+		}
+	}
+	return result
+}
+
+// Queues the event. Never blocks.
+//
+// param: event *sinkEvent   Sample or test event.
+// return: False if the event was dropped.
+func (w *sinkWorker) push(event *sinkEvent) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return false
+	}
+	if event.sample != nil {
+		if !w.lossless && w.samples >= SINK_BUFFER {
+			w.dropped++
+			log.Printf("sink %s is busy, sample of test %q dropped, "+
+				"dropped: %d", w.name, event.sample.TestID, w.dropped)
+			return false
+		}
+		w.samples++
+	}
+	w.events = append(w.events, event)
+	w.notify()
+	return true
+}
+
+// Stops the worker after the queued events are handled.
+func (w *sinkWorker) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	w.notify()
+}
+
+// Wakes the worker. Must be called with the lock held.
+func (w *sinkWorker) notify() {
+	select {
+	case w.signal <- true:
+	default:
+	}
+}
+
+// Returns the oldest queued event, waits for it if the queue is empty.
+//
+// return: Event, nil if the worker is closed and the queue is empty.
+func (w *sinkWorker) next() *sinkEvent {
+	for {
+		w.lock.Lock()
+		if len(w.events) > 0 {
+			event := w.events[0]
+			w.events[0] = nil
+			w.events = w.events[1:]
+			if event.sample != nil {
+				w.samples--
+			}
+			w.lock.Unlock()
+			return event
+		}
+		closed := w.closed
+		w.lock.Unlock()
+		if closed {
+			return nil
+		}
+		<-w.signal
+	}
+}
+
+// Writes events to the sink until the worker is closed.
+func (w *sinkWorker) run() {
+	for event := w.next(); event != nil; event = w.next() {
+		if event.sample != nil {
+			err := w.sink.Write(event.sample)
+			if err != nil {
+				log.Printf("sink %s can not write sample of test %q: %s",
+					w.name, event.sample.TestID, err.Error())
+			}
+			continue
+		}
+		test_sink := w.sink.(TestSink)
+		var err error
+		if event.start {
+			err = test_sink.StartTest(event.test_id)
+		} else {
+			err = test_sink.EndTest(event.test_id, event.reason)
+		}
+		if err != nil {
+			log.Printf("sink %s can not handle test %s: %s",
+				w.name, event.test_id, err.Error())
+		}
+		event.done <- err
+	}
+}
@@ -0,0 +1,179 @@
+package container_monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_SOCKET_LINE  = 1 << 20              // Maximal length of command line.
+	SOCKET_MODE      = 0660                 // Default file mode of the socket.
+	MIN_ACCEPT_DELAY = time.Millisecond * 5 // First delay after accept error.
+	MAX_ACCEPT_DELAY = time.Second          // Maximal delay after accept error.
+)
+
+// Listens unix domain socket for control commands. Every line sent to the
+// socket is a JSON command message, the same as in Redis pub/sub channel,
+// and every reply is a JSON line. Local scripts can drive the monitor
+// without Redis credentials.
+type SocketListener struct {
+	path       string       // Socket file path.
+	mode       os.FileMode  // File mode of the socket.
+	controller *Controller  // Control commands handler.
+	listener   net.Listener // Socket listener.
+	closed     bool         // True after Close.
+	lock       sync.Mutex   // Listener lock.
+}
+
+// Returns new instance of unix socket listener.
+//
+// params: path       string        Socket file path.
+//         controller *Controller   Control commands handler.
+func NewSocketListener(path string, controller *Controller) *SocketListener {
+	return &SocketListener{
+		path:       path,
+		mode:       SOCKET_MODE,
+		controller: controller,
+	}
+}
+
+// Sets file mode of the socket, so clients running as other users can
+// connect. Must be called before Listen.
+//
+// param: mode os.FileMode   File mode, SOCKET_MODE by default.
+func (l *SocketListener) SetMode(mode os.FileMode) {
+	l.mode = mode
+}
+
+// Listens the socket and serves connections until the listener is closed.
+// A stale socket file left by a previous run is removed, other files are
+// never removed. Temporary accept errors, for example too many open files,
+// are retried with growing delay.
+//
+// return: Nil after Close, otherwise the error which stopped listening.
+func (l *SocketListener) Listen() error {
+	err := l.removeStaleSocket()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", l.path)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(l.path, l.mode)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		listener.Close()
+		return nil
+	}
+	l.listener = listener
+	l.lock.Unlock()
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) && l.isClosed() {
+				return nil
+			}
+			if net_err, ok := err.(net.Error); ok && net_err.Temporary() {
+				if delay == 0 {
+					delay = MIN_ACCEPT_DELAY
+				} else if delay *= 2; delay > MAX_ACCEPT_DELAY {
+					delay = MAX_ACCEPT_DELAY
+				}
+				log.Printf("can not accept socket connection, "+
+					"retrying in %s: %s", delay, err.Error())
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go l.serve(conn)
+	}
+}
+
+// Removes the socket file left by a previous run. Returns ErrNotSocket if
+// another kind of file exists at the socket path.
+func (l *SocketListener) removeStaleSocket() error {
+	stat, err := os.Lstat(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if stat.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%w: %s", ErrNotSocket, l.path)
+	}
+	err = os.Remove(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Reads command lines of the connection and writes replies.
+//
+// param: conn net.Conn   Socket connection.
+func (l *SocketListener) serve(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), MAX_SOCKET_LINE)
+	writer := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		var reply *RedisReply
+		message, err := unmarshalRedisMessage(line)
+		if err != nil {
+			reply = newRedisReply(&redisMessage{}, STATUS_ERROR, err)
+		} else {
+			reply = l.controller.Handle(message)
+		}
+		reply_string, err := marshalRedisReply(reply)
+		if err != nil {
+			return
+		}
+		writer.WriteString(reply_string + "\n")
+		err = writer.Flush()
+		if err != nil {
+			log.Printf("can not write socket reply: %s", err.Error())
+			return
+		}
+	}
+	if scanner.Err() != nil {
+		log.Printf("can not read socket command: %s", scanner.Err().Error())
+	}
+}
+
+// Closes the listener and removes the socket file.
+func (l *SocketListener) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+	if l.listener != nil {
+		l.listener.Close()
+		l.listener = nil
+	}
+}
+
+// Returns true if the listener is closed.
+func (l *SocketListener) isClosed() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.closed
+}
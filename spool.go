@@ -0,0 +1,215 @@
+package container_monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	MAX_SPOOL_SIZE   = 64 << 20  // Maximal size of the spool file of a test.
+	SPOOL_FILE_EXT   = ".spool"  // Extension of the spool file.
+	SPOOL_OFFSET_EXT = ".offset" // Extension of the spool read offset file.
+	SPOOL_TEMP_EXT   = ".tmp"    // Extension of the offset file being written.
+)
+
+// Bounded append-only spool of samples on disk. Every sample is one JSON
+// line of the spool file. The read offset is stored next to the spool, so
+// samples left after a restart are replayed from the first unwritten one.
+// The file is truncated when all samples are read.
+type diskSpool struct {
+	path     string   // Spool file path.
+	file     *os.File // Spool file.
+	max_size int64    // Maximal size of the spool file.
+	size     int64    // Size of the spool file.
+	offset   int64    // Offset of the oldest unread sample.
+	count    int      // Count of unread samples.
+	next     int64    // Length of the peeked line.
+}
+
+// Returns spool file path of the test.
+//
+// params: dir     string   Spool directory.
+//         test_id string   Test ID.
+func spoolPath(dir string, test_id string) string {
+	return filepath.Join(dir, url.QueryEscape(test_id)+SPOOL_FILE_EXT)
+}
+
+// Returns test ID of the spool file.
+//
+// param: path string   Spool file path.
+func spoolTestID(path string) (string, error) {
+	return url.QueryUnescape(
+		strings.TrimSuffix(filepath.Base(path), SPOOL_FILE_EXT))
+}
+
+// Opens spool file, creates it if it does not exist.
+//
+// params: path     string   Spool file path.
+//         max_size int64    Maximal size of the spool file.
+func newDiskSpool(path string, max_size int64) (*diskSpool, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	s := &diskSpool{path: path, file: file, max_size: max_size}
+	err = s.open()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reads the stored offset, counts unread samples and cuts off an incomplete
+// last line.
+func (s *diskSpool) open() error {
+	stat, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	s.size = stat.Size()
+	data, err := ioutil.ReadFile(s.path + SPOOL_OFFSET_EXT)
+	if err == nil {
+		s.offset, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil || s.offset > s.size {
+			log.Printf("invalid spool offset in %s, replaying from start",
+				s.path)
+			s.offset = 0
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	reader := bufio.NewReader(io.NewSectionReader(
+		s.file, s.offset, s.size-s.offset))
+	end := s.offset
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		end += int64(len(line))
+		s.count++
+	}
+	if end == s.size {
+		return nil
+	}
+	// The last line was not completed before a crash. It is cut off, so
+	// the next sample is not appended to it.
+	log.Printf("truncating incomplete sample at %d in %s", end, s.path)
+	err = s.file.Truncate(end)
+	if err != nil {
+		return err
+	}
+	s.size = end
+	return nil
+}
+
+// Appends the sample to the spool. Returns ErrSpoolFull if the spool has
+// reached its maximal size.
+func (s *diskSpool) Push(sample *Sample) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if s.size+int64(len(data)) > s.max_size {
+		return ErrSpoolFull
+	}
+	_, err = s.file.Write(data)
+	if err != nil {
+		return err
+	}
+	s.size += int64(len(data))
+	s.count++
+	return s.file.Sync()
+}
+
+// Returns the oldest unread sample, nil if the spool is empty.
+// Lines which can not be decoded are skipped.
+func (s *diskSpool) Peek() (*Sample, error) {
+	for s.count > 0 {
+		reader := bufio.NewReader(io.NewSectionReader(
+			s.file, s.offset, s.size-s.offset))
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		s.next = int64(len(line))
+		sample := &Sample{}
+		err = json.Unmarshal(bytes.TrimSpace(line), sample)
+		if err == nil {
+			return sample, nil
+		}
+		log.Printf("can not decode spooled sample in %s: %s",
+			s.path, err.Error())
+		err = s.Pop()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Removes the oldest sample returned by Peek and stores the read offset.
+// The offset file is replaced atomically, so a crash never leaves a
+// truncated offset.
+func (s *diskSpool) Pop() error {
+	if s.count == 0 || s.next == 0 {
+		return nil
+	}
+	s.offset += s.next
+	s.next = 0
+	s.count--
+	if s.count == 0 {
+		err := s.file.Truncate(0)
+		if err != nil {
+			return err
+		}
+		s.size = 0
+		s.offset = 0
+	}
+	temp_path := s.path + SPOOL_OFFSET_EXT + SPOOL_TEMP_EXT
+	err := ioutil.WriteFile(
+		temp_path, []byte(strconv.FormatInt(s.offset, 10)), 0640)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp_path, s.path+SPOOL_OFFSET_EXT)
+}
+
+// Returns count of unread samples.
+func (s *diskSpool) Len() int {
+	return s.count
+}
+
+// Closes the spool file. Unread samples stay on disk and are replayed when
+// Redis is back or the test is started again.
+func (s *diskSpool) Close() error {
+	return s.file.Close()
+}
+
+// Removes spool files of the test.
+//
+// param: path string   Spool file path.
+func removeSpool(path string) error {
+	for _, file := range []string{path, path + SPOOL_OFFSET_EXT,
+		path + SPOOL_OFFSET_EXT + SPOOL_TEMP_EXT} {
+		err := os.Remove(file)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
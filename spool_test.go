@@ -0,0 +1,217 @@
+package container_monitor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns path of the spool file in a new temporary directory and the
+// function removing the directory.
+func newTestSpoolPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spoolPath(dir, "load/1"), func() { os.RemoveAll(dir) }
+}
+
+// Opens the spool, fails the test on error.
+func openTestSpool(t *testing.T, path string) *diskSpool {
+	spool, err := newDiskSpool(path, MAX_SPOOL_SIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spool
+}
+
+// Pushes samples with times from 1 to count seconds.
+func pushTestSamples(t *testing.T, spool *diskSpool, count int) {
+	for i := 1; i <= count; i++ {
+		err := spool.Push(
+			&Sample{TestID: "load/1", Time: time.Unix(int64(i), 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Pops samples of the spool and checks their times are from first to last
+// seconds, in order.
+func popTestSamples(t *testing.T, spool *diskSpool, first int, last int) {
+	for i := first; i <= last; i++ {
+		sample, err := spool.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sample == nil {
+			t.Fatalf("spool is empty, want sample %d", i)
+		}
+		if sample.Time.Unix() != int64(i) {
+			t.Fatalf("sample time is %d, want %d", sample.Time.Unix(), i)
+		}
+		err = spool.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns size of the file.
+func testFileSize(t *testing.T, path string) int64 {
+	stat, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stat.Size()
+}
+
+func TestSpoolPath(t *testing.T) {
+	path := spoolPath("/var/spool", "load/1 a")
+	if filepath.Dir(path) != "/var/spool" {
+		t.Errorf("spool path %s is outside the directory", path)
+	}
+	test_id, err := spoolTestID(path)
+	if err != nil || test_id != "load/1 a" {
+		t.Errorf("test ID of %s is %q, %v", path, test_id, err)
+	}
+}
+
+func TestSpoolReplaysInOrder(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	spool := openTestSpool(t, path)
+	pushTestSamples(t, spool, 5)
+	if spool.Len() != 5 {
+		t.Fatalf("spool has %d samples, want 5", spool.Len())
+	}
+	popTestSamples(t, spool, 1, 2)
+	spool.Close()
+
+	// The offset file keeps popped samples from being replayed.
+	spool = openTestSpool(t, path)
+	defer spool.Close()
+	if spool.Len() != 3 {
+		t.Fatalf("reopened spool has %d samples, want 3", spool.Len())
+	}
+	popTestSamples(t, spool, 3, 5)
+	sample, err := spool.Peek()
+	if sample != nil || err != nil {
+		t.Errorf("empty spool returns %v, %v", sample, err)
+	}
+}
+
+func TestSpoolTruncatesWhenEmpty(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	spool := openTestSpool(t, path)
+	defer spool.Close()
+	pushTestSamples(t, spool, 3)
+	popTestSamples(t, spool, 1, 3)
+	if size := testFileSize(t, path); size != 0 {
+		t.Errorf("empty spool file has %d bytes", size)
+	}
+	data, err := ioutil.ReadFile(path + SPOOL_OFFSET_EXT)
+	if err != nil || string(data) != "0" {
+		t.Errorf("offset of empty spool is %q, %v", data, err)
+	}
+
+	// Samples pushed after the truncation are read from the start.
+	pushTestSamples(t, spool, 2)
+	popTestSamples(t, spool, 1, 2)
+}
+
+func TestSpoolCutsIncompleteLine(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	spool := openTestSpool(t, path)
+	pushTestSamples(t, spool, 2)
+	spool.Close()
+	size := testFileSize(t, path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"TestID":"load/1","Ti`)
+	file.Close()
+
+	spool = openTestSpool(t, path)
+	defer spool.Close()
+	if spool.Len() != 2 {
+		t.Fatalf("spool has %d samples, want 2", spool.Len())
+	}
+	if got := testFileSize(t, path); got != size {
+		t.Errorf("spool file has %d bytes, want %d", got, size)
+	}
+	err = spool.Push(&Sample{TestID: "load/1", Time: time.Unix(3, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	popTestSamples(t, spool, 1, 3)
+}
+
+func TestSpoolIgnoresInvalidOffset(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	spool := openTestSpool(t, path)
+	pushTestSamples(t, spool, 2)
+	spool.Close()
+	for _, offset := range []string{"garbage", "100000"} {
+		err := ioutil.WriteFile(path+SPOOL_OFFSET_EXT, []byte(offset), 0640)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spool = openTestSpool(t, path)
+		if spool.Len() != 2 {
+			t.Errorf("spool with offset %q has %d samples, want 2",
+				offset, spool.Len())
+		}
+		spool.Close()
+	}
+}
+
+func TestSpoolSkipsUndecodableLine(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	err := ioutil.WriteFile(path, []byte("not json\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := openTestSpool(t, path)
+	defer spool.Close()
+	pushTestSamples(t, spool, 1)
+	popTestSamples(t, spool, 1, 1)
+	if spool.Len() != 0 {
+		t.Errorf("spool has %d samples, want 0", spool.Len())
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	path, remove := newTestSpoolPath(t)
+	defer remove()
+	sample := &Sample{TestID: "load/1", Time: time.Unix(1, 0)}
+	data, err := json.Marshal(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spool has room for one line and a half.
+	spool, err := newDiskSpool(path, int64(len(data)+1)*3/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	err = spool.Push(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = spool.Push(sample)
+	if err != ErrSpoolFull {
+		t.Errorf("push to full spool returns %v, want ErrSpoolFull", err)
+	}
+	if spool.Len() != 1 {
+		t.Errorf("full spool has %d samples, want 1", spool.Len())
+	}
+}
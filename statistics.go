@@ -0,0 +1,75 @@
+package container_monitor
+
+import (
+	"math"
+	"sort"
+)
+
+// Distribution statistics of a metric over a test.
+type Statistics struct {
+	Count  int     // Count of samples.
+	Min    float64 // Minimal value.
+	Max    float64 // Maximal value.
+	Mean   float64 // Average value.
+	StdDev float64 // Standard deviation.
+	P50    float64 // 50th percentile (median).
+	P90    float64 // 90th percentile.
+	P95    float64 // 95th percentile.
+	P99    float64 // 99th percentile.
+}
+
+// Returns statistics of the series values. Returns nil for an empty series.
+//
+// param: points []*SeriesPoint   Metric time series.
+func NewStatistics(points []*SeriesPoint) *Statistics {
+	if len(points) == 0 {
+		return nil
+	}
+	values := make([]float64, len(points))
+	sum := 0.0
+	for i, point := range points {
+		values[i] = point.Value
+		sum += point.Value
+	}
+	sort.Float64s(values)
+	count := float64(len(values))
+	mean := sum / count
+	deviation := 0.0
+	for _, value := range values {
+		deviation += (value - mean) * (value - mean)
+	}
+	return &Statistics{
+		Count:  len(values),
+		Min:    roundStatistic(values[0]),
+		Max:    roundStatistic(values[len(values)-1]),
+		Mean:   roundStatistic(mean),
+		StdDev: roundStatistic(math.Sqrt(deviation / count)),
+		P50:    roundStatistic(percentile(values, 50)),
+		P90:    roundStatistic(percentile(values, 90)),
+		P95:    roundStatistic(percentile(values, 95)),
+		P99:    roundStatistic(percentile(values, 99)),
+	}
+}
+
+// Returns percentile of sorted values with linear interpolation between the
+// closest ranks.
+//
+// params: sorted []float64   Sorted values.
+//         p      float64     Percentile from 0 to 100.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Rounds statistic value to hundredths.
+func roundStatistic(value float64) float64 {
+	return math.Floor(value*100) / 100
+}
@@ -2,10 +2,14 @@ package container_monitor
 
 // System info value object.
 type SystemInfo struct {
-	CPUusage          float64        // Total CPU usage info.
+	CPUusage          float64        // CPU usage in percents of CPULimit.
+	CPUStatistics     *Statistics    // CPU usage statistics.
+	CPULimit          float64        // Effective CPU cores of the container.
+	MemoryLimit       float64        // Container memory limit in megabytes.
 	VirtualMemoryInfo *MemoryInfo    // Total virtual memory usage info.
 	SWAPmemoryInfo    *MemoryInfo    // Total swap memory usage info.
 	Top               []*ProcessInfo // Processes info array.
+	StopReason        string         // Why the test ended, empty if running.
 }
 
 // Returns new system info value object.
@@ -3,407 +3,708 @@ package container_monitor
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/shirou/gopsutil/process"
 	"gopkg.in/redis.v4"
 	"log"
 	"math"
+	"runtime"
 	"sort"
 	"strconv"
 	"time"
 )
 
+const TESTS_KEY = "system:tests" // Redis set of tests with stored data.
+
 // Collects system information, format this and marshal/unmarshal system info
 // from/to JSON object.
 type SystemInfoFactory struct {
-	system_info  *SystemInfo   // System info value object.
-	redis_client *redis.Client // Redis client instance.
+	redis_client *redis.Client     // Redis client instance.
+	cgroup       *CgroupReader     // Container cgroup reader, nil out of container.
+	processes    *ProcessCollector // Process info collector.
 }
 
 // Returns new instance of system info factory.
 //
 // param: The instance of Redis client.
 func NewSystemInfoFactory(client *redis.Client) *SystemInfoFactory {
+	cgroup, err := NewCgroupReader()
+	if err != nil {
+		log.Printf("can not read cgroup, host values are used: %s", err.Error())
+		cgroup = nil
+	}
 	return &SystemInfoFactory{
-		system_info:  NewSystemInfo(),
 		redis_client: client,
+		cgroup:       cgroup,
+		processes:    NewProcessCollector(PROCESS_WORKERS),
 	}
 }
 
-// Writes system information to redis db.
+// Marks the test as started, so a test without samples can be told from
+// an unknown test. The stop of the previous run of the test is cleared.
 //
 // param: test_id string   ID of current test.
-func (f *SystemInfoFactory) UpdateSystemInfo(test_id string) {
+func (f *SystemInfoFactory) StartTest(test_id string) error {
+	pipe := f.redis_client.Pipeline()
+	defer pipe.Close()
+	pref := "system:" + test_id
+	pipe.SAdd(TESTS_KEY, test_id)
+	pipe.HSet(pref+":info", "started",
+		strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+	pipe.HDel(pref+":info", "stopped", "stop_reason")
+	_, err := pipe.Exec()
+	return err
+}
+
+// Marks the test as stopped and records the reason.
+//
+// params: test_id string   ID of the test.
+//         reason  string   Stop reason, one of STOP_REASON constants.
+func (f *SystemInfoFactory) StopTest(test_id string, reason string) error {
+	pipe := f.redis_client.Pipeline()
+	defer pipe.Close()
 	pref := "system:" + test_id
-	err := f.redis_client.Incr(pref + ":steps").Err()
+	pipe.HSet(pref+":info", "stopped",
+		strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10))
+	pipe.HSet(pref+":info", "stop_reason", reason)
+	_, err := pipe.Exec()
+	return err
+}
+
+// Checks that Redis is reachable.
+func (f *SystemInfoFactory) Ping() error {
+	return f.redis_client.Ping().Err()
+}
+
+// Returns sorted IDs of tests with stored data.
+func (f *SystemInfoFactory) ListTests() ([]string, error) {
+	test_ids, err := f.redis_client.SMembers(TESTS_KEY).Result()
 	if err != nil {
-		log.Printf("can not increment steps: %s", err.Error())
+		return nil, err
 	}
+	sort.Strings(test_ids)
+	return test_ids, nil
+}
 
-	cpu, err := f.getCPUPercent()
-	if err != nil {
-		log.Printf("can not get cpu: %s", err.Error())
-		cpu = 0.0
+// Deletes all stored data of the test. The keys are deleted by name, process
+// keys are taken from the process names hash, so data of other tests is
+// never matched.
+//
+// param: test_id string   ID of the test.
+func (f *SystemInfoFactory) DeleteTest(test_id string) error {
+	pref := "system:" + test_id
+	process_keys, err := f.redis_client.HKeys(pref + ":pids:names").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	writer_ids, err := f.redis_client.SMembers(pref + ":writers").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	keys := []string{pref + ":info", pref + ":steps", pref + ":writers",
+		pref + ":cpu", pref + ":limits", pref + ":swap", pref + ":vm"}
+	for _, writer_id := range writer_ids {
+		keys = append(keys, pref+":written:"+writer_id)
+	}
+	for _, field := range PROCESS_FIELDS {
+		keys = append(keys, pref+":pids:"+field)
+	}
+	for _, metric := range []string{SERIES_CPU, SERIES_VM, SERIES_VM_USED,
+		SERIES_SWAP, SERIES_SWAP_USED} {
+		keys = append(keys, seriesKey(pref, metric))
+	}
+	for _, process_key := range process_keys {
+		keys = append(keys,
+			seriesKey(pref, ProcessSeries(process_key, SERIES_PROCESS_CPU)),
+			seriesKey(pref, ProcessSeries(process_key, SERIES_PROCESS_MEM)))
+	}
+	pipe := f.redis_client.Pipeline()
+	defer pipe.Close()
+	pipe.Del(keys...)
+	pipe.SRem(TESTS_KEY, test_id)
+	_, err = pipe.Exec()
+	return err
+}
+
+// Collects one sample of the container system information with all
+// collectors.
+func (f *SystemInfoFactory) CollectSample() *Sample {
+	return f.collectSample(ALL_COLLECTORS, PROCESS_SAMPLE_WINDOW)
+}
+
+// Collects one sample of the container system information.
+// The CPU usage of the container and of every process is measured in the
+// same window.
+//
+// params: collectors []string        Enabled collectors.
+//         window     time.Duration   CPU measurement window.
+func (f *SystemInfoFactory) collectSample(
+	collectors []string, window time.Duration) *Sample {
+	sample := &Sample{
+		Time:       time.Now(),
+		CPULimit:   f.getCPULimit(),
+		Collectors: collectors,
+	}
+
+	processes := make(chan []*ProcessSample, 1)
+	if sample.Has(COLLECTOR_PROCESSES) {
+		go func() {
+			processes <- f.processes.Collect(window)
+		}()
+	} else {
+		processes <- nil
+	}
+
+	if sample.Has(COLLECTOR_CPU) {
+		cpu, err := f.getCPUPercent(window)
+		if err != nil {
+			log.Printf("can not get cpu: %s", err.Error())
+			cpu = 0.0
+		}
+		sample.CPUusage = cpu
 	}
 
-	err = f.redis_client.IncrByFloat(pref+":cpu", cpu).Err()
-	if err != nil {
-		log.Printf("can not increment cpu: %s", err.Error())
+	if sample.Has(COLLECTOR_SWAP) {
+		swap, err := mem.SwapMemory()
+		if err != nil {
+			log.Printf("can not get swap: %s", err.Error())
+			swap = &mem.SwapMemoryStat{}
+		}
+		sample.SWAPmemory = swap
 	}
 
-	swap, err := mem.SwapMemory()
-	if err != nil {
-		log.Printf("can not get swap: %s", err.Error())
+	if sample.Has(COLLECTOR_MEMORY) {
+		virtual_memory, err := f.getVirtualMemory()
+		if err != nil {
+			log.Printf("can not get virtual memory: %s", err.Error())
+			virtual_memory = &mem.VirtualMemoryStat{}
+		}
+		sample.VirtualMemory = virtual_memory
 	}
+	sample.Processes = <-processes
+	if len(sample.Processes) > 0 {
+		f.scaleProcesses(sample.Processes, sample.CPULimit,
+			f.getMemoryLimit(sample.VirtualMemory))
+	}
+	return sample
+}
 
-	err = f.redis_client.HIncrByFloat(
-		pref+":swap", "percent", swap.UsedPercent).Err()
-	if err != nil {
-		log.Printf("can not increment swap percent: %s", err.Error())
+// Converts process usage to percents of the container limits, the same as
+// the usage of the container: CPU usage is divided by the effective CPU
+// cores and memory usage is the resident memory of the process in percents
+// of the container memory limit.
+//
+// params: processes    []*ProcessSample   Collected processes.
+//         cpu_limit    float64            Effective CPU cores.
+//         memory_limit uint64             Memory limit in bytes, 0 if unknown.
+func (f *SystemInfoFactory) scaleProcesses(processes []*ProcessSample,
+	cpu_limit float64, memory_limit uint64) {
+	for _, process := range processes {
+		if cpu_limit > 0.0 {
+			process.CPUPercent = process.CPUPercent / cpu_limit
+		}
+		process.MemoryPercent = 0.0
+		if memory_limit > 0 && process.MemoryInfo != nil {
+			process.MemoryPercent =
+				float64(process.MemoryInfo.RSS) / float64(memory_limit) * 100
+		}
 	}
+}
 
-	err = f.redis_client.HIncrBy(pref+":swap", "total", int64(swap.Total)).Err()
+// Returns memory limit of the container, the total memory out of container.
+//
+// param: virtual_memory *mem.VirtualMemoryStat   Memory of the sample, nil
+//                                               if it was not collected.
+// return: Memory limit in bytes, 0 if it can not be read.
+func (f *SystemInfoFactory) getMemoryLimit(
+	virtual_memory *mem.VirtualMemoryStat) uint64 {
+	if virtual_memory != nil && virtual_memory.Total > 0 {
+		return virtual_memory.Total
+	}
+	virtual_memory, err := f.getVirtualMemory()
 	if err != nil {
-		log.Printf("can not increment swap total: %s", err.Error())
+		log.Printf("can not get memory limit: %s", err.Error())
+		return 0
 	}
+	return virtual_memory.Total
+}
 
-	err = f.redis_client.HIncrBy(pref+":swap", "used", int64(swap.Used)).Err()
+// Writes system information to redis db.
+//
+// param: test_id string   ID of current test.
+func (f *SystemInfoFactory) UpdateSystemInfo(test_id string) {
+	err := f.WriteSample(test_id, f.CollectSample())
 	if err != nil {
-		log.Printf("can not increment swap used: %s", err.Error())
+		log.Printf("can not write sample: %s", err.Error())
 	}
+}
 
-	err = f.redis_client.HIncrBy(pref+":swap", "available", int64(swap.Free)).Err()
-	if err != nil {
-		log.Printf("can not increment swap available: %s", err.Error())
+// Writes the sample to redis db. All values of the sample are written in one
+// MULTI/EXEC transaction, so a failed sample is not counted in steps.
+// The last written sequence number is kept for every writer of the test,
+// so a sample replayed from the spool is never counted twice, while samples
+// of other monitors writing the same test are kept.
+//
+// params: test_id string    ID of current test.
+//         sample  *Sample   Collected sample.
+func (f *SystemInfoFactory) WriteSample(test_id string, sample *Sample) error {
+	pref := "system:" + test_id
+	written_key := pref + ":written:" + sample.WriterID
+	return f.redis_client.Watch(func(tx *redis.Tx) error {
+		if sample.WriterID != "" {
+			last, err := tx.Get(written_key).Int64()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if err == nil && sample.Sequence <= last {
+				return nil
+			}
+		}
+		_, err := tx.MultiExec(func() error {
+			f.queueSample(tx, pref, sample)
+			if sample.WriterID != "" {
+				tx.Set(written_key, sample.Sequence, 0)
+				tx.SAdd(pref+":writers", sample.WriterID)
+			}
+			return nil
+		})
+		return err
+	}, written_key)
+}
+
+// Queues commands writing the sample to the transaction.
+//
+// params: tx     *redis.Tx   Redis transaction.
+//         pref   string      Test key prefix.
+//         sample *Sample     Collected sample.
+func (f *SystemInfoFactory) queueSample(
+	tx *redis.Tx, pref string, sample *Sample) {
+	now := sample.Time
+	tx.Incr(pref + ":steps")
+
+	tx.HSet(pref+":limits", "cpu", strconv.FormatFloat(
+		sample.CPULimit, 'f', -1, 64))
+	if sample.Has(COLLECTOR_CPU) {
+		tx.IncrByFloat(pref+":cpu", sample.CPUusage)
+		f.writeSeriesPoint(tx, pref, SERIES_CPU, now, sample.CPUusage)
+	}
+
+	if sample.Has(COLLECTOR_SWAP) {
+		swap := sample.SWAPmemory
+		tx.HIncrByFloat(pref+":swap", "percent", swap.UsedPercent)
+		tx.HIncrBy(pref+":swap", "total", int64(swap.Total))
+		tx.HIncrBy(pref+":swap", "used", int64(swap.Used))
+		tx.HIncrBy(pref+":swap", "available", int64(swap.Free))
+		f.writeSeriesPoint(tx, pref, SERIES_SWAP, now, swap.UsedPercent)
+		f.writeSeriesPoint(
+			tx, pref, SERIES_SWAP_USED, now, float64(swap.Used))
+	}
+
+	if sample.Has(COLLECTOR_MEMORY) {
+		virtual_memory := sample.VirtualMemory
+		tx.HSet(pref+":limits", "memory", strconv.FormatUint(
+			virtual_memory.Total, 10))
+		tx.HIncrByFloat(pref+":vm", "percent", virtual_memory.UsedPercent)
+		tx.HIncrBy(pref+":vm", "total", int64(virtual_memory.Total))
+		tx.HIncrBy(pref+":vm", "used", int64(virtual_memory.Used))
+		tx.HIncrBy(pref+":vm", "available", int64(virtual_memory.Free))
+		f.writeSeriesPoint(
+			tx, pref, SERIES_VM, now, virtual_memory.UsedPercent)
+		f.writeSeriesPoint(
+			tx, pref, SERIES_VM_USED, now, float64(virtual_memory.Used))
+	}
+
+	now_string := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	for _, process := range sample.Processes {
+		process_key := process.Key()
+		tx.HSetNX(pref+":pids:names", process_key, process.Name)
+		tx.HSet(pref+":pids:status", process_key, process.Status)
+		tx.HSetNX(pref+":pids:cwd", process_key, process.Cwd)
+		tx.HSet(pref+":pids:creation_time", process_key, time.Unix(
+			process.CreateTime, 0).Format("Jan 02, 2006 15:04:05"))
+		tx.HSet(pref+":pids:memory_info", process_key,
+			process.MemoryInfo.String())
+		tx.HSet(pref+":pids:num_threads", process_key,
+			strconv.Itoa(int(process.NumThreads)))
+		tx.HIncrByFloat(
+			pref+":pids:mem_percent", process_key, process.MemoryPercent)
+		f.writeSeriesPoint(tx, pref, ProcessSeries(
+			process_key, SERIES_PROCESS_MEM), now, process.MemoryPercent)
+		tx.HIncrByFloat(
+			pref+":pids:cpu_percent", process_key, process.CPUPercent)
+		f.writeSeriesPoint(tx, pref, ProcessSeries(
+			process_key, SERIES_PROCESS_CPU), now, process.CPUPercent)
+		tx.HIncrBy(pref+":pids:samples", process_key, 1)
+		tx.HSetNX(pref+":pids:first_seen", process_key, now_string)
+		tx.HSet(pref+":pids:last_seen", process_key, now_string)
 	}
+}
 
-	virtual_memory, err := mem.VirtualMemory()
+// Reads system information from redis.
+//
+// param: test_id string   ID of current test.
+// return: New system info or ErrTestNotFound, ErrNoSamples,
+//         *CorruptDataError or Redis error.
+func (f *SystemInfoFactory) ReadSystemInfo(
+	test_id string) (*SystemInfo, error) {
+	return f.ReadTopSystemInfo(test_id, 0)
+}
+
+// Reads system information from redis. All hashes of the test are fetched in
+// one pipeline and joined in memory. The top list is truncated to the
+// heaviest processes. Every call returns a new system info, so the method is
+// safe for concurrent use.
+//
+// params: test_id string   ID of current test.
+//         top     int      Maximal length of the top list, 0 for all.
+func (f *SystemInfoFactory) ReadTopSystemInfo(
+	test_id string, top int) (*SystemInfo, error) {
+	pref := "system:" + test_id
+	pipe := f.redis_client.Pipeline()
+	defer pipe.Close()
+	info_cmd := pipe.HGetAll(pref + ":info")
+	steps_cmd := pipe.Get(pref + ":steps")
+	cpu_cmd := pipe.Get(pref + ":cpu")
+	limits_cmd := pipe.HGetAll(pref + ":limits")
+	swap_cmd := pipe.HGetAll(pref + ":swap")
+	vm_cmd := pipe.HGetAll(pref + ":vm")
+	pids_cmds := make(map[string]*redis.StringStringMapCmd)
+	for _, field := range PROCESS_FIELDS {
+		pids_cmds[field] = pipe.HGetAll(pref + ":pids:" + field)
+	}
+	_, err := pipe.Exec()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	steps_count, err := steps_cmd.Float64()
+	if err == redis.Nil {
+		if len(info_cmd.Val()) == 0 {
+			return nil, ErrTestNotFound
+		}
+		return nil, ErrNoSamples
+	}
 	if err != nil {
-		log.Printf("can not get virtual memory: %s", err.Error())
+		return nil, &CorruptDataError{Key: pref + ":steps", Err: err}
+	}
+	if steps_count == 0.0 {
+		return nil, ErrNoSamples
 	}
 
-	err = f.redis_client.HIncrByFloat(
-		pref+":vm", "percent", virtual_memory.UsedPercent).Err()
-	if err != nil {
-		log.Printf("can not increment vm percent: %s", err.Error())
+	system_info := NewSystemInfo()
+	system_info.StopReason = info_cmd.Val()["stop_reason"]
+	cpu, err := cpu_cmd.Float64()
+	if err != nil && err != redis.Nil {
+		return nil, &CorruptDataError{Key: pref + ":cpu", Err: err}
 	}
+	system_info.CPUusage = f.roundPercents64(cpu / steps_count)
 
-	err = f.redis_client.HIncrBy(pref+":vm", "total", int64(
-		virtual_memory.Total)).Err()
+	limits := limits_cmd.Val()
+	system_info.CPULimit, err = hashFloat(pref+":limits", limits, "cpu")
 	if err != nil {
-		log.Printf("can not increment vm total: %s", err.Error())
+		return nil, err
 	}
-
-	err = f.redis_client.HIncrBy(pref+":vm", "used", int64(
-		virtual_memory.Used)).Err()
+	memory_limit, err := hashFloat(pref+":limits", limits, "memory")
 	if err != nil {
-		log.Printf("can not increment vm used: %s", err.Error())
+		return nil, err
 	}
+	system_info.MemoryLimit = f.toMegaBytes(memory_limit)
 
-	err = f.redis_client.HIncrBy(
-		pref+":vm", "available", int64(virtual_memory.Free)).Err()
+	system_info.SWAPmemoryInfo, err = f.memoryInfo(
+		pref+":swap", swap_cmd.Val(), steps_count)
 	if err != nil {
-		log.Printf("can not increment vm available: %s", err.Error())
+		return nil, err
 	}
-
-	pids, err := process.Pids()
+	system_info.VirtualMemoryInfo, err = f.memoryInfo(
+		pref+":vm", vm_cmd.Val(), steps_count)
 	if err != nil {
-		log.Printf("can not get top: %s", err.Error())
+		return nil, err
 	}
 
-	for _, pid := range pids {
-		process, err := process.NewProcess(pid)
-		if err != nil {
-			log.Printf("can not get process ID %v info: %s", pid, err.Error())
-			continue
-		}
-		pid_string := fmt.Sprintf("%v", pid)
-
-		process_name, err := process.Name()
-		if err != nil {
-			log.Printf("can not get process ID %v name: %s", pid, err.Error())
-			process_name = "undefined"
-		}
-		err = f.redis_client.HSetNX(
-			pref+":pids:names", pid_string, process_name).Err()
-		if err != nil {
-			log.Printf("can not write process %v name: %s", pid, err.Error())
-		}
-		status, err := process.Status()
+	pids := make(map[string]map[string]string)
+	for field, cmd := range pids_cmds {
+		pids[field] = cmd.Val()
+	}
+	system_info.Top = make([]*ProcessInfo, 0, len(pids["names"]))
+	for process_key := range pids["names"] {
+		process_info, err := f.processInfo(
+			pref, pids, process_key, steps_count)
 		if err != nil {
-			log.Printf("can not get process ID  %v status: %s", pid, err.Error())
-			status = "undefined"
+			return nil, err
 		}
+		system_info.Top = append(system_info.Top, process_info)
+	}
+	if len(system_info.Top) > 1 {
+		sort.Sort(ByCPU(system_info.Top))
+	}
+	if top > 0 && len(system_info.Top) > top {
+		system_info.Top = system_info.Top[:top]
+	}
 
-		err = f.redis_client.HSet(pref+":pids:status", pid_string, status).Err()
-		if err != nil {
-			log.Printf("can not write process ID %v status: %s", pid, err.Error())
-		}
+	metrics := []string{SERIES_CPU, SERIES_VM, SERIES_SWAP}
+	for _, process_info := range system_info.Top {
+		metrics = append(metrics,
+			ProcessSeries(process_info.Key, SERIES_PROCESS_CPU),
+			ProcessSeries(process_info.Key, SERIES_PROCESS_MEM))
+	}
+	statistics, err := f.readStatistics(test_id, metrics)
+	if err != nil {
+		return nil, err
+	}
+	system_info.CPUStatistics = statistics[SERIES_CPU]
+	system_info.VirtualMemoryInfo.Statistics = statistics[SERIES_VM]
+	system_info.SWAPmemoryInfo.Statistics = statistics[SERIES_SWAP]
+	for _, process_info := range system_info.Top {
+		process_info.CPUStatistics = statistics[ProcessSeries(
+			process_info.Key, SERIES_PROCESS_CPU)]
+		process_info.MemoryStatistics = statistics[ProcessSeries(
+			process_info.Key, SERIES_PROCESS_MEM)]
+	}
+	return system_info, nil
+}
 
-		cwd, err := process.Cwd()
+// Returns average memory info from the memory hash of the test.
+//
+// params: key         string              Memory hash key.
+//         hash        map[string]string   Summed memory values.
+//         steps_count float64             Count of samples.
+func (f *SystemInfoFactory) memoryInfo(key string, hash map[string]string,
+	steps_count float64) (*MemoryInfo, error) {
+	values := make(map[string]float64)
+	for _, field := range []string{"total", "used", "available", "percent"} {
+		value, err := hashFloat(key, hash, field)
 		if err != nil {
-			cwd = err.Error()
-			log.Printf("can not get process ID %v cwd: %s", pid, err.Error())
+			return nil, err
 		}
+		values[field] = value / steps_count
+	}
+	return &MemoryInfo{
+		Total:       f.toMegaBytes(values["total"]),
+		Used:        f.toMegaBytes(values["used"]),
+		Available:   f.toMegaBytes(values["available"]),
+		UsedPercent: f.roundPercents64(values["percent"]),
+	}, nil
+}
 
-		err = f.redis_client.HSetNX(pref+":pids:cwd", pid_string, cwd).Err()
-		if err != nil {
-			log.Printf("can not write process ID %v cwd: %s", pid, err.Error())
-		}
+// Returns process info joined from the per-metric process hashes.
+//
+// params: pref        string                         Test key prefix.
+//         pids        map[string]map[string]string   Process hashes by field.
+//         process_key string                         Process key.
+//         steps_count float64                        Count of samples.
+func (f *SystemInfoFactory) processInfo(pref string,
+	pids map[string]map[string]string, process_key string,
+	steps_count float64) (*ProcessInfo, error) {
+	pid, _, err := parseProcessKey(process_key)
+	if err != nil {
+		return nil, &CorruptDataError{
+			Key: pref + ":pids:names", Field: process_key, Err: err}
+	}
+	process_info := &ProcessInfo{
+		Key:    process_key,
+		PID:    pid,
+		Name:   pids["names"][process_key],
+		Status: pids["status"][process_key],
+		Cwd:    pids["cwd"][process_key],
+	}
+	if process_info.Cwd == "" {
+		process_info.Cwd = "undefined"
+	}
 
-		create_time, err := process.CreateTime()
-		if err != nil {
-			create_time = time.Unix(0, 0).Unix()
-			log.Printf(
-				"can not get process ID %v creation time: %s", pid, err.Error())
-		}
-		create_time_string := time.Unix(create_time, 0).Format(
+	process_info.CreateTime = pids["creation_time"][process_key]
+	if process_info.CreateTime == "" {
+		process_info.CreateTime = time.Unix(0, 0).Format(
 			"Jan 02, 2006 15:04:05")
-		err = f.redis_client.HSet(
-			pref+":pids:creation_time", pid_string, create_time_string).Err()
-		if err != nil {
-			log.Printf("can not write process ID %v name: %s", pid, err.Error())
-		}
-
-		memory_info, err := process.MemoryInfo()
-		if err != nil {
-			log.Printf(
-				"can not get process ID %v memory info: %s", pid, err.Error())
-		}
-		err = f.redis_client.HSet(
-			pref+":pids:memory_info", pid_string, memory_info.String()).Err()
-		if err != nil {
-			log.Printf(
-				"can not write process ID %v memory info: %s", pid, err.Error())
-		}
-
-		num_threads, err := process.NumThreads()
-		if err != nil {
-			log.Printf(
-				"can not get process ID %v num threads: %s", pid, err.Error())
-		}
-		num_treads_string := strconv.Itoa(int(num_threads))
-		err = f.redis_client.HSet(
-			pref+":pids:num_threads", pid_string, num_treads_string).Err()
-		if err != nil {
-			log.Printf(
-				"can not write process ID %v num threads: %s", pid, err.Error())
-		}
-
-		mem_percent, err := process.MemoryPercent()
-		if err != nil {
-			mem_percent = 0.0
-			log.Printf(
-				"can not get process ID %v memory percent %s", pid, err.Error())
-		}
-		err = f.redis_client.HIncrByFloat(
-			pref+":pids:mem_percent", pid_string, float64(mem_percent)).Err()
-		if err != nil {
-			log.Printf(
-				"can not write process ID %v memory percent: %s", pid, err.Error())
-		}
+	}
 
-		cpu_percent, err := process.Percent(time.Second)
+	process_info.MemoryInfo = &process.MemoryInfoStat{}
+	memory_info, ok := pids["memory_info"][process_key]
+	if ok {
+		err = json.Unmarshal([]byte(memory_info), process_info.MemoryInfo)
 		if err != nil {
-			cpu_percent = 0.0
-			log.Printf(
-				"can not get process ID %v cpu percent %s", pid, err.Error())
+			return nil, &CorruptDataError{
+				Key: pref + ":pids:memory_info", Field: process_key, Err: err}
 		}
+	}
 
-		err = f.redis_client.HIncrByFloat(
-			pref+":pids:cpu_percent", pid_string, cpu_percent).Err()
+	values := make(map[string]float64)
+	for _, field := range []string{"num_threads", "samples", "first_seen",
+		"last_seen", "cpu_percent", "mem_percent"} {
+		values[field], err = hashFloat(
+			pref+":pids:"+field, pids[field], process_key)
 		if err != nil {
-			log.Printf(
-				"can not write process ID %v cpu percent: %s", pid, err.Error())
+			return nil, err
 		}
 	}
+	process_info.NumThreads = int64(values["num_threads"])
+
+	// Processes which started or exited during the test are averaged
+	// over their own lifetime.
+	process_samples := values["samples"]
+	if process_samples == 0.0 {
+		process_samples = steps_count
+	}
+	first_seen := int64(values["first_seen"])
+	last_seen := int64(values["last_seen"])
+	process_info.Samples = int64(process_samples)
+	process_info.FirstSeen = time.Unix(
+		0, first_seen*int64(time.Millisecond)).Format("Jan 02, 2006 15:04:05")
+	process_info.LastSeen = time.Unix(
+		0, last_seen*int64(time.Millisecond)).Format("Jan 02, 2006 15:04:05")
+	process_info.Lifetime = time.Duration(
+		last_seen-first_seen) * time.Millisecond
+	process_info.CPUPercent = f.roundPercents64(
+		values["cpu_percent"] / process_samples)
+	process_info.MemoryPercent = f.roundPercents64(
+		values["mem_percent"] / process_samples)
+	return process_info, nil
 }
 
-// Reads system information from redis.
+// Returns statistics of the metric time series. All series are fetched in
+// one pipeline.
 //
-// param: test_id string   ID of current test.
-func (f *SystemInfoFactory) ReadSystemInfo(test_id string) *SystemInfo {
-	pref := "system:" + test_id
-	steps_count, err := f.redis_client.Get(pref + ":steps").Float64()
-	if err != nil || steps_count == 0.0 {
-		log.Printf("can not read steps count: %s", err.Error())
-		return f.system_info
+// params: test_id string     ID of current test.
+//         metrics []string   Series names.
+// return: Statistics by series name.
+func (f *SystemInfoFactory) readStatistics(
+	test_id string, metrics []string) (map[string]*Statistics, error) {
+	pipe := f.redis_client.Pipeline()
+	defer pipe.Close()
+	cmds := make(map[string]*redis.ZSliceCmd)
+	for _, metric := range metrics {
+		cmds[metric] = pipe.ZRangeWithScores(
+			seriesKey("system:"+test_id, metric), 0, -1)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		return nil, err
 	}
+	statistics := make(map[string]*Statistics)
+	for metric, cmd := range cmds {
+		statistics[metric] = NewStatistics(parseSeries(metric, cmd.Val()))
+	}
+	return statistics, nil
+}
 
-	cpu, err := f.redis_client.Get(pref + ":cpu").Float64()
-	f.system_info.CPUusage = f.roundPercents64(cpu / steps_count)
-
-	swap_info := &MemoryInfo{}
-	swap_total, err := f.redis_client.HGet(pref+":swap", "total").Float64()
+// Returns float value of the hash field. A missing field is 0.
+//
+// params: key   string              Hash key for the error.
+//         hash  map[string]string   Redis hash.
+//         field string              Hash field.
+// return: Value or *CorruptDataError.
+func hashFloat(
+	key string, hash map[string]string, field string) (float64, error) {
+	value_string, ok := hash[field]
+	if !ok {
+		return 0.0, nil
+	}
+	value, err := strconv.ParseFloat(value_string, 64)
 	if err != nil {
-		log.Printf("can not read swap total: %s", err.Error())
-		swap_total = 0.0
+		return 0.0, &CorruptDataError{Key: key, Field: field, Err: err}
 	}
-	swap_used, err := f.redis_client.HGet(pref+":swap", "used").Float64()
-	if err != nil {
-		log.Printf("can not read swap used: %s", err.Error())
-		swap_used = 0.0
+	return value, nil
+}
+
+// Returns total CPU usage in percents or error if the information is not found.
+// Inside a container the usage is read from the container cgroup.
+//
+// param: window time.Duration   CPU measurement window.
+func (f *SystemInfoFactory) getCPUPercent(
+	window time.Duration) (float64, error) {
+	if f.cgroup != nil {
+		return f.getCgroupCPUPercent(window)
 	}
-	swap_available, err := f.redis_client.HGet(pref+":swap", "available").Float64()
+	arr, err := cpu.Percent(window, false)
 	if err != nil {
-		log.Printf("can not read swap available: %s", err.Error())
-		swap_available = 0.0
+		return 0.0, err
 	}
-	swap_percent, err := f.redis_client.HGet(pref+":swap", "percent").Float64()
-	if err != nil {
-		log.Printf("can not read swap percent: %s", err.Error())
-		swap_percent = 0.0
+	var cpu_count float64 = 0.0
+	var total_percent float64 = 0.0
+	for _, cpu_percent := range arr {
+		cpu_count += 1.0
+		total_percent += cpu_percent
 	}
-
-	swap_info.Total = f.toMegaBytes(swap_total / steps_count)
-	swap_info.Used = f.toMegaBytes(swap_used / steps_count)
-	swap_info.Available = f.toMegaBytes(swap_available / steps_count)
-	swap_info.UsedPercent = f.roundPercents64(swap_percent / steps_count)
-	f.system_info.SWAPmemoryInfo = swap_info
-
-	vm_info := &MemoryInfo{}
-	vm_total, err := f.redis_client.HGet(pref+":vm", "total").Float64()
-	if err != nil {
-		log.Printf("can not read swap total: %s", err.Error())
-		vm_total = 0.0
+	if cpu_count == 0.0 {
+		return 0.0, errors.New("can not find cpu info")
 	}
+	return total_percent / cpu_count, nil
+}
 
-	vm_used, err := f.redis_client.HGet(pref+":vm", "used").Float64()
+// Returns CPU cores available to the container. This is the cgroup CPU quota
+// if it is set, otherwise the count of host cores.
+func (f *SystemInfoFactory) getCPULimit() float64 {
+	cores := float64(runtime.NumCPU())
+	if f.cgroup == nil {
+		return cores
+	}
+	limit, err := f.cgroup.CPULimit()
 	if err != nil {
-		log.Printf("can not read virtual memory used: %s", err.Error())
-		vm_used = 0.0
+		log.Printf("can not get cgroup cpu limit: %s", err.Error())
+		return cores
 	}
+	if limit <= 0.0 || limit > cores {
+		return cores
+	}
+	return limit
+}
 
-	vm_available, err := f.redis_client.HGet(pref+":vm", "available").Float64()
+// Returns CPU usage of the container cgroup in percents of the CPU cores
+// available to the container.
+func (f *SystemInfoFactory) getCgroupCPUPercent(
+	window time.Duration) (float64, error) {
+	start_usage, err := f.cgroup.CPUUsage()
 	if err != nil {
-		log.Printf("can not read virtual memory available: %s", err.Error())
-		vm_available = 0.0
+		return 0.0, err
 	}
-
-	vm_percent, err := f.redis_client.HGet(pref+":vm", "percent").Float64()
+	start := time.Now()
+	time.Sleep(window)
+	end_usage, err := f.cgroup.CPUUsage()
 	if err != nil {
-		log.Printf("can not read virtual memory percent: %s", err.Error())
-		vm_percent = 0.0
+		return 0.0, err
+	}
+	elapsed := time.Since(start)
+	if end_usage < start_usage || elapsed <= 0 {
+		return 0.0, errors.New("can not find cgroup cpu usage")
 	}
+	return float64(end_usage-start_usage) /
+		(float64(elapsed.Nanoseconds()) * f.getCPULimit()) * 100, nil
+}
 
-	top_length, err := f.redis_client.HLen(pref + ":pids:names").Result()
+// Returns virtual memory usage. Inside a container the used memory is read
+// from the container cgroup and the total is the container memory limit.
+func (f *SystemInfoFactory) getVirtualMemory() (*mem.VirtualMemoryStat, error) {
+	virtual_memory, err := mem.VirtualMemory()
 	if err != nil {
-		log.Printf("can not read top list length: %s", err.Error())
-		return f.system_info
+		return nil, err
 	}
-
-	top_list, err := f.redis_client.HGetAll(pref + ":pids:names").Result()
+	if f.cgroup == nil {
+		return virtual_memory, nil
+	}
+	used, err := f.cgroup.MemoryUsage()
 	if err != nil {
-		log.Printf("can not read top list: %s", err.Error())
-		return f.system_info
+		log.Printf("can not get cgroup memory usage: %s", err.Error())
+		return virtual_memory, nil
 	}
-
-	f.system_info.Top = make([]*ProcessInfo, top_length)
-	count := 0
-	for pid, process_name := range top_list {
-		process_info := &ProcessInfo{}
-		process_info.Name = process_name
-		pid_int, err := strconv.ParseInt(pid, 10, 32)
-		if err != nil {
-			log.Printf("can not parse process pid %s", pid)
-			continue
-		}
-		process_info.PID = int32(pid_int)
-
-		status, err := f.redis_client.HGet(pref+":pids:status", pid).Result()
-		if err != nil {
-			log.Printf("can not read processID %v status %s", pid, err.Error())
-		}
-		cwd, err := f.redis_client.HGet(pref+":pids:cwd", pid).Result()
-		if err != nil {
-			cwd = "undefined"
-		}
-
-		creation_time_string, err := f.redis_client.HGet(
-			pref+":pids:creation_time", pid).Result()
-		if err != nil {
-			log.Printf(
-				"can not read process ID: %v creation date %s", pid, err.Error())
-			creation_time_string = time.Unix(0, 0).Format(
-				"Jan 02, 2006 15:04:05")
-		}
-		memory_info_bytes, err := f.redis_client.HGet(
-			pref+":pids:memory_info", pid).Bytes()
-		if err != nil {
-			log.Printf(
-				"can not read process ID: %v memory info %s", pid, err.Error())
-		}
-		process_memory_info := &process.MemoryInfoStat{}
-		err = json.Unmarshal(memory_info_bytes, process_memory_info)
-		if err != nil {
-			log.Printf(
-				"can not unmarshall process ID: %v memory info %s",
-				pid, err.Error())
-		}
-
-		num_threads, err := f.redis_client.HGet(
-			pref+":pids:num_threads", pid).Int64()
-		if err != nil {
-			log.Printf(
-				"can not read process ID: %v num threads %s", pid, err.Error())
-		}
-
-		mem_percent, err := f.redis_client.HGet(
-			pref+":pids:mem_percent", pid).Float64()
-		if err != nil {
-			log.Printf(
-				"can not read process ID: %v memory percent %s", pid, err.Error())
-			mem_percent = 0.0
-		}
-		log.Printf("process pid %s memory percent: %d", pid, mem_percent)
-		process_cpu_percent, err := f.redis_client.HGet(
-			pref+":pids:cpu_percent", pid).Float64()
-		if err != nil {
-			process_cpu_percent = 0.0
-			log.Printf(
-				"can not read process ID: %v CPU percent %s", pid, err.Error())
-		}
-		process_info.CPUPercent = f.roundPercents64(
-			process_cpu_percent / steps_count)
-		process_info.MemoryPercent = f.roundPercents64(mem_percent / steps_count)
-		process_info.NumThreads = num_threads
-		process_info.MemoryInfo = process_memory_info
-		process_info.CreateTime = creation_time_string
-		process_info.Cwd = cwd
-		process_info.Status = status
-		f.system_info.Top[count] = process_info
-		count++
-	}
-
-	vm_info.Total = f.toMegaBytes(vm_total / steps_count)
-	vm_info.Used = f.toMegaBytes(vm_used / steps_count)
-	vm_info.Available = f.toMegaBytes(vm_available / steps_count)
-	vm_info.UsedPercent = f.roundPercents64(vm_percent / steps_count)
-	f.system_info.VirtualMemoryInfo = vm_info
-	if f.system_info.Top != nil && len(f.system_info.Top) > 1 {
-		sort.Sort(ByCPU(f.system_info.Top))
-	}
-	return f.system_info
-}
-
-// Returns total CPU usage in percents or error if the information is not found.
-func (f *SystemInfoFactory) getCPUPercent() (float64, error) {
-	arr, err := cpu.Percent(time.Second, false)
+	limit, err := f.cgroup.MemoryLimit()
 	if err != nil {
-		return 0.0, err
+		log.Printf("can not get cgroup memory limit: %s", err.Error())
+		limit = 0
 	}
-	var cpu_count float64 = 0.0
-	var total_percent float64 = 0.0
-	for _, cpu_percent := range arr {
-		cpu_count += 1.0
-		total_percent += cpu_percent
+	if limit > 0 && limit < virtual_memory.Total {
+		virtual_memory.Total = limit
 	}
-	if cpu_count == 0.0 {
-		return 0.0, errors.New("can not find cpu info")
+	virtual_memory.Used = used
+	virtual_memory.Free = 0
+	if virtual_memory.Total > used {
+		virtual_memory.Free = virtual_memory.Total - used
 	}
-	return total_percent / cpu_count, nil
+	virtual_memory.UsedPercent = 0.0
+	if virtual_memory.Total > 0 {
+		virtual_memory.UsedPercent =
+			float64(used) / float64(virtual_memory.Total) * 100
+	}
+	return virtual_memory, nil
 }
 
 // Converts bytes to megabytes.
@@ -0,0 +1,188 @@
+package container_monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	COLLECTOR_CPU       = "cpu"       // Total CPU usage.
+	COLLECTOR_MEMORY    = "memory"    // Virtual memory usage.
+	COLLECTOR_SWAP      = "swap"      // Swap memory usage.
+	COLLECTOR_PROCESSES = "processes" // Processes info.
+
+	MIN_SAMPLE_INTERVAL  = time.Millisecond * 100 // Minimal sampling interval.
+	DEFAULT_MAX_DURATION = time.Hour * 12         // Test limit without keepalive.
+)
+
+// All collectors of the sample.
+var ALL_COLLECTORS = []string{
+	COLLECTOR_CPU, COLLECTOR_MEMORY, COLLECTOR_SWAP, COLLECTOR_PROCESSES,
+}
+
+// Duration which is decoded from JSON string like "250ms" or "30s", or from
+// number of milliseconds.
+type Duration time.Duration
+
+// Decodes duration from JSON.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v) * time.Millisecond)
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(duration)
+	default:
+		return errors.New("invalid duration " + string(data))
+	}
+	return nil
+}
+
+// Encodes duration to JSON string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Options of a stress test carried in the start message.
+type TestOptions struct {
+	Interval    Duration // Sampling interval, SAMPLE_INTERVAL if zero.
+	Collectors  []string // Enabled collectors, all if empty.
+	Include     []string // Regular expressions of process names to keep.
+	Exclude     []string // Regular expressions of process names to drop.
+	Top         int      // Maximal count of processes in a sample, 0 for all.
+	MaxDuration Duration // Test is stopped after the duration, 0 for default.
+	Keepalive   Duration // Test is stopped without keepalive, 0 for no watchdog.
+}
+
+// Test options prepared for sample filtering.
+type sampleFilter struct {
+	interval     time.Duration    // Sampling interval.
+	keepalive    time.Duration    // Inactivity timeout of the stress client.
+	collectors   []string         // Enabled collectors.
+	include      []*regexp.Regexp // Process names to keep.
+	exclude      []*regexp.Regexp // Process names to drop.
+	top          int              // Maximal count of processes.
+	max_duration time.Duration    // Maximal test duration.
+}
+
+// Checks the options and returns the sample filter. A test without max
+// duration and keepalive is limited to DEFAULT_MAX_DURATION.
+//
+// param: options *TestOptions   Test options, nil for defaults.
+func newSampleFilter(options *TestOptions) (*sampleFilter, error) {
+	filter := &sampleFilter{
+		interval:     SAMPLE_INTERVAL,
+		collectors:   ALL_COLLECTORS,
+		max_duration: DEFAULT_MAX_DURATION,
+	}
+	if options == nil {
+		return filter, nil
+	}
+	if options.Interval != 0 {
+		filter.interval = time.Duration(options.Interval)
+	}
+	if filter.interval < MIN_SAMPLE_INTERVAL {
+		return nil, errors.New("interval is less than " +
+			MIN_SAMPLE_INTERVAL.String())
+	}
+	if len(options.Collectors) > 0 {
+		for _, collector := range options.Collectors {
+			if !hasCollector(ALL_COLLECTORS, collector) {
+				return nil, errors.New("unknown collector " + collector)
+			}
+		}
+		filter.collectors = options.Collectors
+	}
+	for _, expression := range options.Include {
+		include, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		filter.include = append(filter.include, include)
+	}
+	for _, expression := range options.Exclude {
+		exclude, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, err
+		}
+		filter.exclude = append(filter.exclude, exclude)
+	}
+	if options.Top < 0 || options.MaxDuration < 0 || options.Keepalive < 0 {
+		return nil, errors.New("negative top, max duration or keepalive")
+	}
+	filter.top = options.Top
+	filter.keepalive = time.Duration(options.Keepalive)
+	if options.MaxDuration != 0 || options.Keepalive != 0 {
+		filter.max_duration = time.Duration(options.MaxDuration)
+	}
+	return filter, nil
+}
+
+// Returns copy of the sample with the enabled collectors and the selected
+// processes only.
+//
+// param: sample *Sample   Sample collected for all tests.
+func (f *sampleFilter) apply(sample *Sample) *Sample {
+	result := *sample
+	result.Collectors = nil
+	for _, collector := range sample.Collectors {
+		if hasCollector(f.collectors, collector) {
+			result.Collectors = append(result.Collectors, collector)
+		}
+	}
+	if !result.Has(COLLECTOR_PROCESSES) {
+		result.Processes = nil
+		return &result
+	}
+	result.Processes = make([]*ProcessSample, 0, len(sample.Processes))
+	for _, process := range sample.Processes {
+		if f.accept(process.Name) {
+			result.Processes = append(result.Processes, process)
+		}
+	}
+	if f.top > 0 && len(result.Processes) > f.top {
+		sort.Sort(ProcessSamplesByCPU(result.Processes))
+		result.Processes = result.Processes[:f.top]
+	}
+	return &result
+}
+
+// Returns true if the process name passes include and exclude filters.
+func (f *sampleFilter) accept(name string) bool {
+	for _, exclude := range f.exclude {
+		if exclude.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, include := range f.include {
+		if include.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the collector is in the list.
+func hasCollector(collectors []string, collector string) bool {
+	for _, c := range collectors {
+		if c == collector {
+			return true
+		}
+	}
+	return false
+}
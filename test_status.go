@@ -0,0 +1,32 @@
+package container_monitor
+
+import (
+	"time"
+)
+
+// Status value object of a running test.
+type TestStatus struct {
+	TestID    string        // Test ID.
+	Paused    bool          // Samples of the test are not written.
+	StartedAt time.Time     // Test start time.
+	Uptime    time.Duration // Time since the test start.
+	Samples   int64         // Count of samples stored or queued for Redis.
+}
+
+// Sorts test statuses by test ID.
+type ByTestID []*TestStatus
+
+// Returns length of sortable array.
+func (b ByTestID) Len() int {
+	return len(b)
+}
+
+// Swaps array indexes.
+func (b ByTestID) Swap(i, j int) {
+	b[i], b[j] = b[j], b[i]
+}
+
+// Returns sort rule.
+func (b ByTestID) Less(i, j int) bool {
+	return b[i].TestID < b[j].TestID
+}